@@ -0,0 +1,98 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+// Command prefixgen generates a Go source file implementing a regular expression or
+// a lexer as a state machine with no dependency on this module, for use with go
+// generate:
+//
+//	//go:generate go run github.com/vikashmadhow/prefix_regex_matcher/cmd/prefixgen -name Number -pattern "[0-9]+" -o number.go
+//	//go:generate go run github.com/vikashmadhow/prefix_regex_matcher/cmd/prefixgen -name Calc -lexer calc.lex -o calc_lexer.go
+//
+// A lexer definition has one token type per line, its id followed by white space and
+// its pattern, in priority order. Blank lines and lines starting with # are ignored.
+// The package defaults to $GOPACKAGE, set by go generate.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/vikashmadhow/prefix_regex_matcher/lexer"
+	"github.com/vikashmadhow/prefix_regex_matcher/regex"
+)
+
+func main() {
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package of the generated file")
+	name := flag.String("name", "", "prefix of the identifiers generated")
+	pattern := flag.String("pattern", "", "regular expression to generate")
+	definition := flag.String("lexer", "", "file of the lexer definition to generate")
+	out := flag.String("o", "", "generated file, standard output if empty")
+	flag.Parse()
+
+	if err := generate(*pkg, *name, *pattern, *definition, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "prefixgen:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(pkg, name, pattern, definition, out string) error {
+	if (pattern == "") == (definition == "") {
+		return fmt.Errorf("one of -pattern or -lexer is required")
+	}
+	options := regex.GoOptions{Package: pkg, Name: name}
+	var src bytes.Buffer
+	if pattern != "" {
+		r, err := regex.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		if err = r.GenerateGo(&src, options); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(definition)
+		if err != nil {
+			return err
+		}
+		types, err := readDefinition(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", definition, err)
+		}
+		if err = lexer.New(types...).GenerateGo(&src, options); err != nil {
+			return err
+		}
+	}
+	if out == "" {
+		_, err := os.Stdout.Write(src.Bytes())
+		return err
+	}
+	return os.WriteFile(out, src.Bytes(), 0o644)
+}
+
+// readDefinition reads the token types of a lexer definition, compiling their patterns.
+func readDefinition(in io.Reader) ([]*lexer.TokenType, error) {
+	var types []*lexer.TokenType
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.IndexAny(text, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing pattern of %s", line, text)
+		}
+		id, pattern := text[:i], strings.TrimSpace(text[i:])
+		compiled, err := regex.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		types = append(types, &lexer.TokenType{Id: id, Pattern: pattern, Compiled: compiled})
+	}
+	return types, scanner.Err()
+}
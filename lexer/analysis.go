@@ -0,0 +1,142 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package lexer
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Analysis reports the token types of a lexer which can never produce a token, those
+	// matching nothing, those competing for the same texts and those matching the empty
+	// string.
+	Analysis struct {
+		// Shadowed are the token types which never produce a token as every text they
+		// match is matched by a token type defined before them, in definition order.
+		Shadowed []Shadowing
+
+		// Unmatched are the token types matching no text, such as [a-z]&[0-9], which
+		// never produce a token either but are not shadowed.
+		Unmatched []*TokenType
+
+		// Overlaps are the pairs of token types matching some same texts.
+		Overlaps []Overlap
+
+		// Empty are the token types matching the empty string, which would produce
+		// empty tokens.
+		Empty []*TokenType
+	}
+
+	// Shadowing is a token type which never produces a token, and the token types
+	// defined before it which produce the tokens of the texts it matches.
+	Shadowing struct {
+		TokenType *TokenType
+		By        []*TokenType
+	}
+
+	// Overlap is a pair of token types matching the same texts, such as Example, for
+	// which First, defined before Second, produces the token.
+	Overlap struct {
+		First, Second *TokenType
+		Example       string
+	}
+)
+
+// Analyze analyses the token types of the lexer on the DFA matching all of them at
+// once, which is the product of their automata, to find the token types which can
+// never win, such as a keyword defined after an identifier matching it, those matching
+// nothing, those with
+// overlapping languages, with the shortest text matched by both, and those matching
+// the empty string. The token types of each mode are analysed separately, a token type
+// never producing a token if it is shadowed in all the modes in which it is active.
+func (lexer *Lexer) Analyze() *Analysis {
+	n := len(lexer.Definition)
+	active, shadowed := make([]int, n), make([]int, n)
+	by := make([]map[int]bool, n)
+	empty, unmatched := make([]bool, n), make([]bool, n)
+	overlaps := map[[2]int]string{}
+	for _, m := range lexer.modes {
+		a := m.set.Analyze()
+		for _, i := range m.types {
+			active[i]++
+		}
+		for k, shadows := range a.Shadowed {
+			i := m.types[k]
+			shadowed[i]++
+			if by[i] == nil {
+				by[i] = map[int]bool{}
+			}
+			for _, j := range shadows {
+				by[i][m.types[j]] = true
+			}
+		}
+		for _, o := range a.Overlaps {
+			pair := [2]int{m.types[o.First], m.types[o.Second]}
+			if e, ok := overlaps[pair]; !ok || len(o.Example) < len(e) || len(o.Example) == len(e) && o.Example < e {
+				overlaps[pair] = o.Example
+			}
+		}
+		for _, k := range a.Empty {
+			empty[m.types[k]] = true
+		}
+		for _, k := range a.Unmatched {
+			unmatched[m.types[k]] = true
+		}
+	}
+
+	analysis := &Analysis{}
+	for i, d := range lexer.Definition {
+		if unmatched[i] {
+			analysis.Unmatched = append(analysis.Unmatched, d)
+		} else if active[i] > 0 && shadowed[i] == active[i] {
+			s := Shadowing{TokenType: d}
+			for _, j := range slices.Sorted(maps.Keys(by[i])) {
+				s.By = append(s.By, lexer.Definition[j])
+			}
+			analysis.Shadowed = append(analysis.Shadowed, s)
+		}
+		if empty[i] {
+			analysis.Empty = append(analysis.Empty, d)
+		}
+	}
+	pairs := slices.SortedFunc(maps.Keys(overlaps), func(a, b [2]int) int {
+		if a[0] != b[0] {
+			return a[0] - b[0]
+		}
+		return a[1] - b[1]
+	})
+	for _, pair := range pairs {
+		analysis.Overlaps = append(analysis.Overlaps, Overlap{lexer.Definition[pair[0]], lexer.Definition[pair[1]], overlaps[pair]})
+	}
+	return analysis
+}
+
+// String returns the findings of the analysis, one per line.
+func (a *Analysis) String() string {
+	var s strings.Builder
+	for _, shadowed := range a.Shadowed {
+		s.WriteString(shadowed.TokenType.Id + " never produces a token")
+		for i, t := range shadowed.By {
+			if i == 0 {
+				s.WriteString(": shadowed by ")
+			} else {
+				s.WriteString(", ")
+			}
+			s.WriteString(t.Id)
+		}
+		s.WriteString("\n")
+	}
+	for _, t := range a.Unmatched {
+		s.WriteString(t.Id + " matches nothing\n")
+	}
+	for _, o := range a.Overlaps {
+		s.WriteString(o.First.Id + " and " + o.Second.Id + " overlap, e.g., on " + strconv.Quote(o.Example) + "\n")
+	}
+	for _, t := range a.Empty {
+		s.WriteString(t.Id + " matches the empty string\n")
+	}
+	return s.String()
+}
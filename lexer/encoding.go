@@ -0,0 +1,276 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package lexer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/vikashmadhow/prefix_regex_matcher/regex"
+)
+
+// lexerMagic starts the binary encoding of a lexer, which is followed by the version
+// of the encoding (EncodingVersion), the ids, patterns, modes and mode actions of the
+// token types, the names and binary encodings of the sets of the modes without all the
+// token types, and the binary encoding of the set of their regular expressions.
+// Modulators, the buffer size, error recovery and the counting of columns are not
+// encoded.
+const lexerMagic = "PRXL"
+
+// EncodingVersion is the version of the binary and JSON encodings of lexers, which is
+// incremented whenever they change, independently of regex.EncodingVersion which
+// versions the encoding of the set of their patterns. Version 2 added the modes and
+// mode actions of token types, and version 3 the sets of the modes.
+const EncodingVersion = 3
+
+type (
+	// encodedLexer is the JSON encoding of a lexer.
+	encodedLexer struct {
+		Version    int                        `json:"version"`
+		TokenTypes []encodedTokenType         `json:"tokenTypes"`
+		Set        *regex.RegexSet            `json:"set"`
+		Modes      map[string]*regex.RegexSet `json:"modes,omitempty"`
+	}
+
+	encodedTokenType struct {
+		Id      string              `json:"id"`
+		Pattern string              `json:"pattern"`
+		Modes   []string            `json:"modes,omitempty"`
+		Actions []encodedModeAction `json:"actions,omitempty"`
+	}
+
+	encodedModeAction struct {
+		Op   ModeOp `json:"op"`
+		Mode string `json:"mode,omitempty"`
+	}
+)
+
+// MarshalBinary encodes the token types of the lexer with their compiled patterns, so
+// that the lexer can be built ahead of time and loaded with UnmarshalBinary without
+// compiling its patterns.
+func (lexer *Lexer) MarshalBinary() ([]byte, error) {
+	b := binary.AppendUvarint([]byte(lexerMagic), EncodingVersion)
+	b = binary.AppendUvarint(b, uint64(len(lexer.Definition)))
+	for _, d := range lexer.Definition {
+		b = appendString(b, d.Id)
+		b = appendString(b, d.Pattern)
+		b = binary.AppendUvarint(b, uint64(len(d.Modes)))
+		for _, m := range d.Modes {
+			b = appendString(b, m)
+		}
+		b = binary.AppendUvarint(b, uint64(len(d.Actions)))
+		for _, a := range d.Actions {
+			b = binary.AppendUvarint(b, uint64(a.Op))
+			b = appendString(b, a.Mode)
+		}
+	}
+	modes := lexer.modeSets()
+	b = binary.AppendUvarint(b, uint64(len(modes)))
+	for _, name := range slices.Sorted(maps.Keys(modes)) {
+		set, err := modes[name].MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = appendString(b, name)
+		b = appendString(b, string(set))
+	}
+	set, err := lexer.set.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, set...), nil
+}
+
+// UnmarshalBinary loads the token types of a lexer encoded by MarshalBinary, returning
+// an error wrapping regex.ErrEncoding if the data is invalid or was encoded with another
+// version.
+func (lexer *Lexer) UnmarshalBinary(data []byte) error {
+	if len(data) < len(lexerMagic) || string(data[:len(lexerMagic)]) != lexerMagic {
+		return fmt.Errorf("%w: missing header %s", regex.ErrEncoding, lexerMagic)
+	}
+	data = data[len(lexerMagic):]
+	version, data, ok := readUvarint(data)
+	if !ok || version != EncodingVersion {
+		return fmt.Errorf("%w: version %d instead of %d", regex.ErrEncoding, version, EncodingVersion)
+	}
+	count, data, ok := readUvarint(data)
+	if !ok || count > uint64(len(data)) {
+		return fmt.Errorf("%w: truncated or corrupt data", regex.ErrEncoding)
+	}
+	types := make([]encodedTokenType, count)
+	for i := range types {
+		if types[i].Id, data, ok = readString(data); ok {
+			types[i].Pattern, data, ok = readString(data)
+		}
+		if ok {
+			types[i].Modes, data, ok = readStrings(data)
+		}
+		if ok {
+			types[i].Actions, data, ok = readActions(data)
+		}
+		if !ok {
+			return fmt.Errorf("%w: truncated or corrupt data", regex.ErrEncoding)
+		}
+	}
+	count, data, ok = readUvarint(data)
+	if !ok || count > uint64(len(data)) {
+		return fmt.Errorf("%w: truncated or corrupt data", regex.ErrEncoding)
+	}
+	modes := make(map[string]*regex.RegexSet, count)
+	for range count {
+		var name, encoded string
+		if name, data, ok = readString(data); ok {
+			encoded, data, ok = readString(data)
+		}
+		if !ok {
+			return fmt.Errorf("%w: truncated or corrupt data", regex.ErrEncoding)
+		}
+		modes[name] = &regex.RegexSet{}
+		if err := modes[name].UnmarshalBinary([]byte(encoded)); err != nil {
+			return err
+		}
+	}
+	var set regex.RegexSet
+	if err := set.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	return lexer.load(types, &set, modes)
+}
+
+// MarshalJSON encodes the token types of the lexer with their compiled patterns in
+// JSON, with the same content as MarshalBinary.
+func (lexer *Lexer) MarshalJSON() ([]byte, error) {
+	encoded := encodedLexer{Version: EncodingVersion, Set: lexer.set, Modes: lexer.modeSets()}
+	for _, d := range lexer.Definition {
+		t := encodedTokenType{Id: d.Id, Pattern: d.Pattern, Modes: d.Modes}
+		for _, a := range d.Actions {
+			t.Actions = append(t.Actions, encodedModeAction(a))
+		}
+		encoded.TokenTypes = append(encoded.TokenTypes, t)
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON loads the token types of a lexer encoded by MarshalJSON.
+func (lexer *Lexer) UnmarshalJSON(data []byte) error {
+	var encoded encodedLexer
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("%w: %v", regex.ErrEncoding, err)
+	}
+	if encoded.Version != EncodingVersion {
+		return fmt.Errorf("%w: version %d instead of %d", regex.ErrEncoding, encoded.Version, EncodingVersion)
+	}
+	if encoded.Set == nil {
+		return fmt.Errorf("%w: no set", regex.ErrEncoding)
+	}
+	for name, set := range encoded.Modes {
+		if set == nil {
+			return fmt.Errorf("%w: no set for mode %s", regex.ErrEncoding, name)
+		}
+	}
+	return lexer.load(encoded.TokenTypes, encoded.Set, encoded.Modes)
+}
+
+// modeSets returns the sets of the modes of the lexer without all its token types, by
+// mode name, which are encoded with the set of all the token types.
+func (lexer *Lexer) modeSets() map[string]*regex.RegexSet {
+	sets := map[string]*regex.RegexSet{}
+	for name, m := range lexer.modes {
+		if m.set != lexer.set {
+			sets[name] = m.set
+		}
+	}
+	return sets
+}
+
+// load sets the token types of the lexer to those decoded, with the compiled patterns
+// of the set and the sets of its modes decoded with it.
+func (lexer *Lexer) load(types []encodedTokenType, set *regex.RegexSet, modes map[string]*regex.RegexSet) error {
+	if len(types) != len(set.Regexes) {
+		return fmt.Errorf("%w: %d token types for %d patterns", regex.ErrEncoding, len(types), len(set.Regexes))
+	}
+	lexer.Definition = make([]*TokenType, len(types))
+	lexer.TokenTypes = make(map[string]*TokenType)
+	for i, t := range types {
+		d := &TokenType{Id: t.Id, Pattern: t.Pattern, Compiled: set.Regexes[i], Modes: t.Modes}
+		for _, a := range t.Actions {
+			if a.Op < PushMode || a.Op > SwitchMode {
+				return fmt.Errorf("%w: unknown mode action %d", regex.ErrEncoding, a.Op)
+			}
+			d.Actions = append(d.Actions, ModeAction(a))
+		}
+		lexer.Definition[i] = d
+		lexer.TokenTypes[t.Id] = d
+	}
+	lexer.set = set
+	if lexer.bufferSize == 0 {
+		lexer.bufferSize = 1024
+	}
+	if modes == nil {
+		modes = map[string]*regex.RegexSet{}
+	}
+	if err := lexer.buildModes(modes); err != nil {
+		return fmt.Errorf("%w: %v", regex.ErrEncoding, err)
+	}
+	return nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func readUvarint(data []byte) (uint64, []byte, bool) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, false
+	}
+	return v, data[n:], true
+}
+
+func readStrings(data []byte) ([]string, []byte, bool) {
+	n, data, ok := readUvarint(data)
+	if !ok || n > uint64(len(data)) {
+		return nil, nil, false
+	}
+	var values []string
+	for range n {
+		var s string
+		if s, data, ok = readString(data); !ok {
+			return nil, nil, false
+		}
+		values = append(values, s)
+	}
+	return values, data, true
+}
+
+func readActions(data []byte) ([]encodedModeAction, []byte, bool) {
+	n, data, ok := readUvarint(data)
+	if !ok || n > uint64(len(data)) {
+		return nil, nil, false
+	}
+	var actions []encodedModeAction
+	for range n {
+		var op uint64
+		var mode string
+		if op, data, ok = readUvarint(data); ok {
+			mode, data, ok = readString(data)
+		}
+		if !ok {
+			return nil, nil, false
+		}
+		actions = append(actions, encodedModeAction{ModeOp(op), mode})
+	}
+	return actions, data, true
+}
+
+func readString(data []byte) (string, []byte, bool) {
+	n, data, ok := readUvarint(data)
+	if !ok || n > uint64(len(data)) {
+		return "", nil, false
+	}
+	return string(data[:n]), data[n:], true
+}
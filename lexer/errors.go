@@ -0,0 +1,63 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package lexer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/vikashmadhow/prefix_regex_matcher/regex"
+)
+
+type (
+	// LexError describes input which is not matched by any token type of the lexer:
+	// the character at which matching failed with its position, the text of the token
+	// being matched before it, and the token types partially matching that text with
+	// the characters which could have followed.
+	LexError struct {
+		// Line and Column are the position of Rune, starting at 1.
+		Line, Column int
+
+		// Offset is the position of Rune in bytes from the start of the input.
+		Offset int
+
+		// Rune is the character which could not be matched, or -1 at the end of the
+		// input.
+		Rune rune
+
+		// Text is the text of the token being matched, before Rune.
+		Text string
+
+		// Partial are the token types partially matching Text, in priority order.
+		Partial []PartialMatch
+	}
+
+	// PartialMatch is a token type partially matching the text of a LexError, with the
+	// classes of the characters which could have continued the match.
+	PartialMatch struct {
+		TokenType *TokenType
+		Expected  []regex.CharClass
+	}
+)
+
+func (e *LexError) Error() string {
+	var msg strings.Builder
+	msg.WriteString("error at " + strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Column))
+	if len(e.Partial) > 0 {
+		msg.WriteString(": potential partial match(es): ")
+		for i, p := range e.Partial {
+			if i > 0 {
+				msg.WriteString(", ")
+			}
+			msg.WriteString(p.TokenType.Id + " (next expected character(s): ")
+			for j, c := range p.Expected {
+				if j > 0 {
+					msg.WriteString(", ")
+				}
+				msg.WriteString(c.Pattern)
+			}
+			msg.WriteString(")")
+		}
+	}
+	return msg.String()
+}
@@ -0,0 +1,28 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package lexer
+
+import (
+	"errors"
+	"io"
+
+	"github.com/vikashmadhow/prefix_regex_matcher/regex"
+)
+
+// GenerateGo writes a Go source file implementing the lexer as a state machine with no
+// dependency on this module, as regex.RegexSet.GenerateGo does for the patterns of its
+// token types, with the ids of the token types as the names of the patterns: calling
+// <Name>Next repeatedly on the rest of the input, with the last character of the
+// previous token, returns the index of the type of each token in <Name>Names and its
+// length. Modulators are not part of the generated code, and lexers with modes are
+// not supported.
+func (lexer *Lexer) GenerateGo(w io.Writer, options regex.GoOptions) error {
+	if len(lexer.modes) > 1 {
+		return errors.New("lexer modes are not supported in generated code")
+	}
+	options.Names = make([]string, len(lexer.Definition))
+	for i, d := range lexer.Definition {
+		options.Names[i] = d.Id
+	}
+	return lexer.set.GenerateGo(w, options)
+}
@@ -5,12 +5,11 @@ package lexer
 
 import (
 	"bufio"
-	"errors"
 	"io"
 	"iter"
-	"strconv"
+	"slices"
 	"strings"
-	"unicode/utf8"
+	"unicode/utf16"
 
 	"github.com/vikashmadhow/prefix_regex_matcher/regex"
 	"github.com/vikashmadhow/prefix_regex_matcher/seq"
@@ -19,30 +18,77 @@ import (
 type Lexer struct {
 	Definition []*TokenType
 	TokenTypes map[string]*TokenType
-	matchers   []*TokenMatcher
 	modulators []Modulator
 	bufferSize int
+
+	// recover is true if the lexer produces Error tokens on unrecognised input and
+	// continues, stopping after maxErrors errors if maxErrors is positive.
+	recover   bool
+	maxErrors int
+
+	// columns is the unit in which columns are counted and tabWidth the distance
+	// between tab stops, tabs counting as one unit if it is less than 2.
+	columns  ColumnUnit
+	tabWidth int
+
+	// set matches the patterns of all the token types at once, by their index in
+	// the definition which is also their priority.
+	set *regex.RegexSet
+
+	// modes are the sets of the patterns of the token types active in each mode.
+	modes map[string]*mode
 }
 
 func New(definition ...*TokenType) *Lexer {
-	var matchers []*TokenMatcher
-	for _, d := range definition {
+	compiled := make([]*regex.CompiledRegex, len(definition))
+	for i, d := range definition {
 		if d.Compiled == nil {
-			d.Compiled = regex.NewRegex(d.Pattern)
+			d.Compiled = regex.MustCompile(d.Pattern)
 		}
-		matchers = append(matchers, &TokenMatcher{d, d.Compiled.Matcher()})
+		compiled[i] = d.Compiled
+	}
+	set, err := regex.SetOf(compiled...)
+	if err != nil {
+		panic(err)
 	}
 	tokenTypes := make(map[string]*TokenType)
 	for _, d := range definition {
 		tokenTypes[d.Id] = d
 	}
-	return &Lexer{definition, tokenTypes, matchers, nil, 1024}
+	lexer := &Lexer{Definition: definition, TokenTypes: tokenTypes, bufferSize: 1024, set: set}
+	if err := lexer.buildModes(nil); err != nil {
+		panic(err)
+	}
+	return lexer
 }
 
 func (lexer *Lexer) Buffer(size int) {
 	lexer.bufferSize = size
 }
 
+// Recover makes the lexer continue after unrecognised input instead of ending the token
+// sequence: the run of characters in error, starting with the character at which no
+// token type could be matched and extending to the next character which can start a
+// token, is produced as an Error token together with the error describing it. Lexing
+// stops after maxErrors errors, without an EOF token, or never if maxErrors is not
+// positive.
+func (lexer *Lexer) Recover(maxErrors int) {
+	lexer.recover = true
+	lexer.maxErrors = maxErrors
+}
+
+// Columns sets the unit in which the columns of the positions of tokens and errors are
+// counted, runes by default.
+func (lexer *Lexer) Columns(unit ColumnUnit) {
+	lexer.columns = unit
+}
+
+// TabWidth sets the distance between tab stops, a tab moving the column to the next
+// one. Tabs count as one column if the width is less than 2, the default.
+func (lexer *Lexer) TabWidth(width int) {
+	lexer.tabWidth = width
+}
+
 func (lexer *Lexer) Modulator(modulator ...Modulator) {
 	lexer.modulators = append(lexer.modulators, modulator...)
 }
@@ -79,123 +125,198 @@ func (lexer *Lexer) LexSeq(in io.Reader) iter.Seq2[Token, error] {
 	return next
 }
 
+// lex produces the tokens of the input by maximal munch: the text of each token is
+// the longest one matched by a token type, the highest priority one if several match
+// it. The characters read past the end of a token, while a longer token was still
+// possible, are replayed to match the next token, so that a float rule such as
+// '\d+\.\d+' failing on '1.x' gives back '.x' after the token '1'. Only the token
+// types active in the mode at the top of the mode stack are matched, the stack being
+// changed by the actions of the type of each token produced.
 func (lexer *Lexer) lex(in io.Reader) iter.Seq2[Token, error] {
-	column, line := 1, 1
-	scanner := bufio.NewReader(in)
-
 	return func(yield func(t Token, e error) bool) {
-		var matching int
-		var previousMatches []*TokenMatcher
-		var previousPartialMatches []*TokenMatcher
-
-		start := 0
 		bufferSize := lexer.bufferSize
 		if bufferSize < 8 {
 			bufferSize = 8
 		}
-		input := make([]byte, bufferSize)
+		reader := bufio.NewReaderSize(in, bufferSize)
+		pos := Position{Line: 1, Column: 1}
+
+		// the mode stack, and the matchers of the modes entered so far
+		var modes *ModeStack
+		stacks := modeStacks{}
+		matchers := map[string]*regex.SetMatcher{}
+		current := lexer.modes[DefaultMode]
+		matcher := current.set.Matcher()
+		matchers[DefaultMode] = matcher
+
+		// text are the characters matched since the start of the token; replay are
+		// those read after the end of the previous token which remain to be matched
+		var text, replay []char
+
+		// accept is the length of the longest text fully matched, by token type
+		// acceptType, or -1 if none is
+		accept, acceptType := -1, -1
+
+		errorCount := 0
+		var readErr error
+		next := func() (char, bool) {
+			if len(replay) > 0 {
+				c := replay[0]
+				replay = replay[1:]
+				return c, true
+			}
+			if readErr != nil {
+				return char{-1, 0}, false
+			}
+			r, size, err := reader.ReadRune()
+			if err != nil {
+				readErr = err
+				return char{-1, 0}, false
+			}
+			return char{r, size}, true
+		}
+
 		for {
-			read, err := scanner.Read(input[start:])
-			read += start
-			start = 0
-
-			//fmt.Println(read)
-			//fmt.Println(string(input[:read]))
-
-			for position := 0; position < read; {
-				matching = 0
-				previousMatches = nil
-				previousPartialMatches = nil
-
-				r, n := utf8.DecodeRune(input[position:read])
-				if r == utf8.RuneError {
-					start = copy(input[0:], input[position:read])
-					break
+			c, more := next()
+			r := c.r
+
+			// the token types fully matched before r, which depends on r for token
+			// patterns ending with assertions, such as 'if\b', and those partially
+			// matched, for reporting errors; empty tokens are never produced
+			full, partial := matcher.FullBefore(r), matcher.Partial()
+			if len(full) > 0 && len(text) > 0 {
+				accept, acceptType = len(text), current.types[full[0]]
+			}
+			if more && matcher.MatchNext(r) != regex.NoMatch {
+				text = append(text, c)
+				continue
+			}
+			if !more && len(text) == 0 {
+				break
+			}
+			if accept == -1 {
+				err := lexer.matchError(matcher, current, partial, lexer.advance(pos, text), r)
+				if !lexer.recover {
+					yield(Token{}, err)
+					return
 				}
-				//fmt.Println("  >>", string(r))
-
-				for _, m := range lexer.matchers {
-					fillPrevious(m, &previousMatches, &previousPartialMatches)
-					if m.matcher.LastMatch != regex.NoMatch {
-						match := m.matcher.MatchNext(r)
-						if match != regex.NoMatch {
-							matching++
-						}
-					}
+
+				// resynchronise by skipping the first character of the text, and those
+				// after it which cannot start a token; the rest of the text is replayed
+				if more {
+					text = append(text, c)
 				}
-				if matching == 0 {
-					t, e := lexer.produceToken(previousMatches, previousPartialMatches, line, column)
-					if !yield(t, e) || e != nil {
-						return
+				skipped := []char{text[0]}
+				replay = append(slices.Clone(text[1:]), replay...)
+				text = nil
+				for {
+					matcher.ResetAfter(skipped[len(skipped)-1].r)
+					c, ok := next()
+					if !ok {
+						break
 					}
-				} else {
-					position += n
-					if r == '\n' {
-						line++
-						column = 1
-					} else {
-						column++
+					if matcher.MatchNext(c.r) != regex.NoMatch {
+						text = append(text, c)
+						break
 					}
+					skipped = append(skipped, c)
+				}
+				var token Token
+				token, pos = lexer.token(Error, skipped, pos, modes)
+				errorCount++
+				if !yield(token, err) || lexer.maxErrors > 0 && errorCount >= lexer.maxErrors {
+					return
 				}
+				continue
 			}
-			for _, m := range lexer.matchers {
-				fillPrevious(m, &previousMatches, &previousPartialMatches)
+
+			// the token is the longest text matched and the characters after it are
+			// matched again
+			tokenType := lexer.Definition[acceptType]
+			token, end := lexer.token(tokenType.Id, text[:accept], pos, modes)
+			rest := slices.Clone(text[accept:])
+			if more {
+				rest = append(rest, c)
 			}
-			if err != nil {
-				//fmt.Println(err)
-				yield(lexer.produceToken(previousMatches, previousPartialMatches, line, column))
-				break
+			replay = append(rest, replay...)
+			pos = end
+
+			if len(tokenType.Actions) > 0 {
+				modes = stacks.apply(modes, tokenType.Actions)
+				current = lexer.modes[modes.Top()]
+				if matcher = matchers[modes.Top()]; matcher == nil {
+					matcher = current.set.Matcher()
+					matchers[modes.Top()] = matcher
+				}
+			}
+
+			// token patterns starting with assertions, such as '\bif', see the end of
+			// the token
+			matcher.ResetAfter(text[accept-1].r)
+			text, accept, acceptType = nil, -1, -1
+			if !yield(token, nil) {
+				return
 			}
 		}
-		yield(Token{Type: EOF, Text: "", Line: line, Column: column}, nil)
+		if readErr != nil && readErr != io.EOF {
+			yield(Token{}, readErr)
+			return
+		}
+		eof, _ := lexer.token(EOF, nil, pos, modes)
+		yield(eof, nil)
 	}
 }
 
-func fillPrevious(m *TokenMatcher, previousMatches *[]*TokenMatcher, previousPartialMatches *[]*TokenMatcher) {
-	if m.matcher.LastMatch == regex.FullMatch {
-		*previousMatches = append(*previousMatches, m)
-	} else if m.matcher.LastMatch == regex.PartialMatch {
-		*previousPartialMatches = append(*previousPartialMatches, m)
+// char is a character read from the input with the number of bytes it was read
+// from, which is 1 for an invalid UTF-8 byte read as utf8.RuneError.
+type char struct {
+	r    rune
+	size int
+}
+
+// token returns the token of the type and text starting at the position in the
+// modes, and the position following it.
+func (lexer *Lexer) token(tokenType string, text []char, start Position, modes *ModeStack) (Token, Position) {
+	end := lexer.advance(start, text)
+	runes := make([]rune, len(text))
+	for i, c := range text {
+		runes[i] = c.r
 	}
+	return Token{tokenType, string(runes), start.Line, start.Column, modes, start.Offset, end.Line, end.Column, end.Offset}, end
 }
 
-func (lexer *Lexer) produceToken(
-	previousMatches []*TokenMatcher,
-	previousPartialMatches []*TokenMatcher,
-	line int, column int) (Token, error) {
-	var token Token
-	var err error
-	if len(previousMatches) > 0 {
-		match := previousMatches[0]
-		token = Token{match.def.Id, match.matcher.Matched, line, column - utf8.RuneCountInString(match.matcher.Matched)}
-		err = nil
-	} else {
-		token = Token{}
-		msg := "error at " + strconv.Itoa(line) + ":" + strconv.Itoa(column)
-		if len(previousPartialMatches) > 0 {
-			msg += ": potential partial match(es): "
-			for i, m := range previousPartialMatches {
-				if i > 0 {
-					msg += ", "
-				}
-				trans := m.matcher.Compiled.Dfa.Trans[m.matcher.State]
-				msg += m.def.Id + " (next expected character(s): "
-				first := true
-				for k := range trans {
-					if first {
-						first = false
-					} else {
-						msg += ", "
-					}
-					msg += k.Pattern()
-				}
-				msg += ")"
-			}
+// advance returns the position following the text starting at the position, counting
+// columns in the units of the lexer and moving tabs to the next tab stop.
+func (lexer *Lexer) advance(p Position, text []char) Position {
+	for _, c := range text {
+		switch {
+		case c.r == '\n':
+			p.Line++
+			p.Column = 1
+		case c.r == '\t' && lexer.tabWidth > 1:
+			p.Column += lexer.tabWidth - (p.Column-1)%lexer.tabWidth
+		case lexer.columns == ByteColumns:
+			p.Column += c.size
+		case lexer.columns == UTF16Columns:
+			p.Column += utf16.RuneLen(c.r)
+		default:
+			p.Column++
 		}
-		err = errors.New(msg)
+		p.Offset += c.size
 	}
-	for _, m := range lexer.matchers {
-		m.matcher.Reset()
+	return p
+}
+
+// matchError returns the error of the character r, at the position, not continuing
+// the text of the matcher of the mode with any token type, listing the token types
+// partially matching the text.
+func (lexer *Lexer) matchError(matcher *regex.SetMatcher, mode *mode, partial []int, pos Position, r rune) *LexError {
+	err := &LexError{Line: pos.Line, Column: pos.Column, Offset: pos.Offset, Rune: r, Text: matcher.Matched}
+	for _, p := range partial {
+		err.Partial = append(err.Partial, PartialMatch{
+			TokenType: lexer.Definition[mode.types[p]],
+			Expected:  matcher.PatternMatcher(p).Expected(),
+		})
 	}
-	return token, err
+	return err
 }
@@ -3,11 +3,14 @@
 package lexer
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"slices"
+	"strings"
 	"testing"
 
+	"github.com/vikashmadhow/prefix_regex_matcher/regex"
 	"github.com/vikashmadhow/prefix_regex_matcher/seq"
 )
 
@@ -32,14 +35,14 @@ func TestBasicLexer(t *testing.T) {
 	}
 
 	if !slices.Equal(tokens, []Token{
-		{"LET", "let", 1, 1},
-		{"SPC", " ", 1, 4},
-		{"ID", "x", 1, 5},
-		{"SPC", " ", 1, 6},
-		{"EQ", "=", 1, 7},
-		{"SPC", "  ", 1, 8},
-		{"INT", "1000", 1, 10},
-		{EOF, "", 1, 14},
+		{"LET", "let", 1, 1, nil, 0, 1, 4, 3},
+		{"SPC", " ", 1, 4, nil, 3, 1, 5, 4},
+		{"ID", "x", 1, 5, nil, 4, 1, 6, 5},
+		{"SPC", " ", 1, 6, nil, 5, 1, 7, 6},
+		{"EQ", "=", 1, 7, nil, 6, 1, 8, 7},
+		{"SPC", "  ", 1, 8, nil, 7, 1, 10, 9},
+		{"INT", "1000", 1, 10, nil, 9, 1, 14, 13},
+		{EOF, "", 1, 14, nil, 13, 1, 14, 13},
 	}) {
 		t.Error("Invalid output", tokens)
 	}
@@ -66,14 +69,14 @@ func TestLexerError(t *testing.T) {
 	}
 
 	if !slices.Equal(tokens, []*Token{
-		{"LET", "let", 1, 1},
-		{"SPC", " ", 1, 4},
-		{"ID", "x", 1, 5},
-		{"SPC", " ", 1, 6},
-		{"EQ", "=", 1, 7},
-		{"SPC", "  ", 1, 8},
-		{"INT", "1000", 1, 10},
-		{EOF, "", 1, 14},
+		{"LET", "let", 1, 1, nil, 0, 1, 4, 3},
+		{"SPC", " ", 1, 4, nil, 3, 1, 5, 4},
+		{"ID", "x", 1, 5, nil, 4, 1, 6, 5},
+		{"SPC", " ", 1, 6, nil, 5, 1, 7, 6},
+		{"EQ", "=", 1, 7, nil, 6, 1, 8, 7},
+		{"SPC", "  ", 1, 8, nil, 7, 1, 10, 9},
+		{"INT", "1000", 1, 10, nil, 9, 1, 14, 13},
+		{EOF, "", 1, 14, nil, 13, 1, 14, 13},
 	}) {
 		t.Error("Invalid output", tokens)
 	}
@@ -115,26 +118,26 @@ func TestMultiline(t *testing.T) {
 
 	//fmt.Println(tokens)
 	_, err := matchTokens(tokens, []*Token{
-		{"LET", "let", 1, 1},
-		//{"SPC", " ", 1, 4},
-		{"ID", "x", 1, 5},
-		//{"SPC", " ", 1, 6},
-		{"EQ", "=", 1, 7},
-		//{"SPC", " ", 1, 8},
-		{"INT", "1000", 1, 9},
-		//{"SPC", "\n\t\t\t\t\t\t\t ", 2, 0},
-		{"LET", "let", 2, 9},
-		//{"SPC", " ", 2, 12},
-		{"ID", "y", 2, 13},
-		//{"SPC", " ", 2, 14},
-		{"EQ", "=", 2, 15},
-		{"ID", "x", 2, 16},
-		{"PLUS", "+", 2, 17},
-		{"ID", "y", 2, 18},
-		{"TIME", "*", 2, 19},
-		{"PLUS", "-", 2, 20},
-		{"INT", "2000", 2, 21},
-		{EOF, "", 2, 25},
+		{"LET", "let", 1, 1, nil, 0, 1, 4, 3},
+		//{"SPC", " ", 1, 4, nil, 3, 1, 5, 4},
+		{"ID", "x", 1, 5, nil, 4, 1, 6, 5},
+		//{"SPC", " ", 1, 6, nil, 5, 1, 7, 6},
+		{"EQ", "=", 1, 7, nil, 6, 1, 8, 7},
+		//{"SPC", " ", 1, 8, nil, 7, 1, 9, 8},
+		{"INT", "1000", 1, 9, nil, 8, 1, 13, 12},
+		//{"SPC", "\n\t\t\t\t\t\t\t ", 2, 0, nil, 12, 2, 9, 21},
+		{"LET", "let", 2, 9, nil, 21, 2, 12, 24},
+		//{"SPC", " ", 2, 12, nil, 24, 2, 13, 25},
+		{"ID", "y", 2, 13, nil, 25, 2, 14, 26},
+		//{"SPC", " ", 2, 14, nil, 26, 2, 15, 27},
+		{"EQ", "=", 2, 15, nil, 27, 2, 16, 28},
+		{"ID", "x", 2, 16, nil, 28, 2, 17, 29},
+		{"PLUS", "+", 2, 17, nil, 29, 2, 18, 30},
+		{"ID", "y", 2, 18, nil, 30, 2, 19, 31},
+		{"TIME", "*", 2, 19, nil, 31, 2, 20, 32},
+		{"PLUS", "-", 2, 20, nil, 32, 2, 21, 33},
+		{"INT", "2000", 2, 21, nil, 33, 2, 25, 37},
+		{EOF, "", 2, 25, nil, 37, 2, 25, 37},
 	})
 
 	if err != nil {
@@ -162,11 +165,11 @@ func TestUnicode(t *testing.T) {
 
 	fmt.Println(tokens)
 	_, err := matchTokens(tokens, []*Token{
-		{"LET", "let", 1, 1},
-		{"ID", "A日本語", 1, 5},
-		{"EQ", "=", 1, 10},
-		{"INT", "1000", 1, 12},
-		{EOF, "", 1, 16},
+		{"LET", "let", 1, 1, nil, 0, 1, 4, 3},
+		{"ID", "A日本語", 1, 5, nil, 4, 1, 9, 14},
+		{"EQ", "=", 1, 10, nil, 15, 1, 11, 16},
+		{"INT", "1000", 1, 12, nil, 17, 1, 16, 21},
+		{EOF, "", 1, 16, nil, 21, 1, 16, 21},
 	})
 
 	if err != nil {
@@ -194,10 +197,10 @@ func TestReverse(t *testing.T) {
 
 	fmt.Println(tokens)
 	_, err := matchTokens(tokens, []*Token{
-		{"INT", "1000", 1, 12},
-		{"EQ", "=", 1, 10},
-		{"ID", "A日本語", 1, 5},
-		{"LET", "let", 1, 1},
+		{"INT", "1000", 1, 12, nil, 17, 1, 16, 21},
+		{"EQ", "=", 1, 10, nil, 15, 1, 11, 16},
+		{"ID", "A日本語", 1, 5, nil, 4, 1, 9, 14},
+		{"LET", "let", 1, 1, nil, 0, 1, 4, 3},
 	})
 
 	if err != nil {
@@ -245,11 +248,11 @@ func TestReverseAlternate(t *testing.T) {
 
 	fmt.Println(tokens)
 	_, err := matchTokens(tokens, []*Token{
-		{"ID", "A日本語", 1, 5},
-		{"LET", "let", 1, 1},
-		{"INT", "1000", 1, 12},
-		{"EQ", "=", 1, 10},
-		{"PLUS", "+", 1, 17},
+		{"ID", "A日本語", 1, 5, nil, 4, 1, 9, 14},
+		{"LET", "let", 1, 1, nil, 0, 1, 4, 3},
+		{"INT", "1000", 1, 12, nil, 17, 1, 16, 21},
+		{"EQ", "=", 1, 10, nil, 15, 1, 11, 16},
+		{"PLUS", "+", 1, 17, nil, 22, 1, 18, 23},
 	})
 
 	if err != nil {
@@ -282,11 +285,11 @@ func TestEndError(t *testing.T) {
 	}
 
 	_, err := matchTokens(tokens, []*Token{
-		{"LET", "let", 1, 1},
-		{"ID", "x", 1, 5},
-		{"EQ", ":=", 1, 7},
-		{"INT", "1000", 1, 10},
-		{EOF, "", 1, 14},
+		{"LET", "let", 1, 1, nil, 0, 1, 4, 3},
+		{"ID", "x", 1, 5, nil, 4, 1, 6, 5},
+		{"EQ", ":=", 1, 7, nil, 6, 1, 9, 8},
+		{"INT", "1000", 1, 10, nil, 9, 1, 14, 13},
+		{EOF, "", 1, 14, nil, 13, 1, 14, 13},
 	})
 
 	if err != nil {
@@ -305,3 +308,624 @@ func matchTokens(t1 []*Token, t2 []*Token) (bool, error) {
 	}
 	return true, nil
 }
+
+func TestTokenParts(t *testing.T) {
+	float := NewTokenType("FLOAT", "(?<int>[0-9]+)\\.(?<frac>[0-9]+)(e(?<exp>[0-9]+))?")
+	l := New(float, &TokenType{Id: "SPC", Pattern: "\\s+"})
+	var parts []map[string]string
+	for token := range l.LexTextSeq("12.5 3.14e2") {
+		if token.Type == "FLOAT" {
+			parts = append(parts, float.Parts(token))
+		}
+	}
+	if len(parts) != 2 ||
+		parts[0]["int"] != "12" || parts[0]["frac"] != "5" || len(parts[0]) != 2 ||
+		parts[1]["int"] != "3" || parts[1]["frac"] != "14" || parts[1]["exp"] != "2" {
+		t.Error("Invalid token parts", parts)
+	}
+}
+
+func TestLexerWordBoundary(t *testing.T) {
+	l := New(
+		&TokenType{Id: "IF", Pattern: "if\\b"},
+		&TokenType{Id: "ID", Pattern: "[a-z]+"},
+		&TokenType{Id: "LP", Pattern: "\\("},
+		&TokenType{Id: "SPC", Pattern: "\\s+"},
+	)
+	var tokens []Token
+	for token := range l.LexTextSeq("iffy if(if") {
+		tokens = append(tokens, token)
+	}
+	if !slices.Equal(tokens, []Token{
+		{"ID", "iffy", 1, 1, nil, 0, 1, 5, 4},
+		{"SPC", " ", 1, 5, nil, 4, 1, 6, 5},
+		{"IF", "if", 1, 6, nil, 5, 1, 8, 7},
+		{"LP", "(", 1, 8, nil, 7, 1, 9, 8},
+		{"IF", "if", 1, 9, nil, 8, 1, 11, 10},
+		{EOF, "", 1, 11, nil, 10, 1, 11, 10},
+	}) {
+		t.Error("Invalid output", tokens)
+	}
+}
+
+func TestLexerLazyComment(t *testing.T) {
+	l := New(
+		&TokenType{Id: "COMMENT", Pattern: "/\\*(.|\\n)*?\\*/"},
+		&TokenType{Id: "ID", Pattern: "[a-z]+"},
+		&TokenType{Id: "DIV", Pattern: "/"},
+		&TokenType{Id: "MUL", Pattern: "\\*"},
+		&TokenType{Id: "SPC", Pattern: "\\s+"},
+	)
+	var tokens []Token
+	for token := range l.LexTextSeq("a /* b */ c */") {
+		tokens = append(tokens, token)
+	}
+	if !slices.Equal(tokens, []Token{
+		{"ID", "a", 1, 1, nil, 0, 1, 2, 1},
+		{"SPC", " ", 1, 2, nil, 1, 1, 3, 2},
+		{"COMMENT", "/* b */", 1, 3, nil, 2, 1, 10, 9},
+		{"SPC", " ", 1, 10, nil, 9, 1, 11, 10},
+		{"ID", "c", 1, 11, nil, 10, 1, 12, 11},
+		{"SPC", " ", 1, 12, nil, 11, 1, 13, 12},
+		{"MUL", "*", 1, 13, nil, 12, 1, 14, 13},
+		{"DIV", "/", 1, 14, nil, 13, 1, 15, 14},
+		{EOF, "", 1, 15, nil, 14, 1, 15, 14},
+	}) {
+		t.Error("Invalid output", tokens)
+	}
+}
+
+func TestLexerManyTokenTypes(t *testing.T) {
+	var types []*TokenType
+	for i := range 200 {
+		types = append(types, SimpleTokenType(fmt.Sprintf("kw%d", i)))
+	}
+	types = append(types,
+		&TokenType{Id: "ID", Pattern: "[a-z][a-z0-9]*"},
+		&TokenType{Id: "SPC", Pattern: "\\s+"},
+	)
+	l := New(types...)
+	var tokens []Token
+	for token := range l.LexTextSeq("kw12 kw199 kw200 k") {
+		tokens = append(tokens, token)
+	}
+	if !slices.Equal(tokens, []Token{
+		{"kw12", "kw12", 1, 1, nil, 0, 1, 5, 4},
+		{"SPC", " ", 1, 5, nil, 4, 1, 6, 5},
+		{"kw199", "kw199", 1, 6, nil, 5, 1, 11, 10},
+		{"SPC", " ", 1, 11, nil, 10, 1, 12, 11},
+		{"ID", "kw200", 1, 12, nil, 11, 1, 17, 16},
+		{"SPC", " ", 1, 17, nil, 16, 1, 18, 17},
+		{"ID", "k", 1, 18, nil, 17, 1, 19, 18},
+		{EOF, "", 1, 19, nil, 18, 1, 19, 18},
+	}) {
+		t.Error("Invalid output", tokens)
+	}
+}
+
+func TestLexerEncoding(t *testing.T) {
+	l := New(
+		&TokenType{Id: "IF", Pattern: "if\\b"},
+		&TokenType{Id: "ID", Pattern: "[a-z]+"},
+		&TokenType{Id: "NUM", Pattern: "(?<int>\\d+)(\\.(?<frac>\\d+))?"},
+		&TokenType{Id: "COMMENT", Pattern: "/\\*(.|\\n)*?\\*/"},
+		&TokenType{Id: "SPC", Pattern: "\\s+"},
+	)
+	text := "if iffy 3.14 /* a */ x */"
+	var expected []Token
+	for token := range l.LexTextSeq(text) {
+		expected = append(expected, token)
+	}
+
+	data, err := l.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded, loadedJSON Lexer
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(jsonData, &loadedJSON); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []*Lexer{&loaded, &loadedJSON} {
+		var tokens []Token
+		for token := range l.LexTextSeq(text) {
+			tokens = append(tokens, token)
+		}
+		if !slices.Equal(tokens, expected) {
+			t.Error("loaded lexer produced", tokens, "instead of", expected)
+		}
+		parts := l.TokenTypes["NUM"].Parts(Token{"NUM", "3.14", 1, 9, nil, 8, 1, 13, 12})
+		if parts["int"] != "3" || parts["frac"] != "14" {
+			t.Error("unexpected parts of a number from the loaded lexer", parts)
+		}
+	}
+
+	if err := loaded.UnmarshalBinary(data[:len(data)/2]); !errors.Is(err, regex.ErrEncoding) {
+		t.Error("expected an encoding error, got", err)
+	}
+
+	// lexers encoded by the previous version, without the sets of modes, are rejected
+	old := slices.Clone(data)
+	old[len(lexerMagic)] = 2
+	if err := loaded.UnmarshalBinary(old); !errors.Is(err, regex.ErrEncoding) || !strings.Contains(err.Error(), "version 2 instead of 3") {
+		t.Error("expected a version error, got", err)
+	}
+	encoded, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded = []byte(strings.Replace(string(encoded), `"version":3`, `"version":2`, 1))
+	if err := json.Unmarshal(encoded, &loaded); !errors.Is(err, regex.ErrEncoding) || !strings.Contains(err.Error(), "version 2 instead of 3") {
+		t.Error("expected a version error from JSON, got", err)
+	}
+}
+
+func TestLexerGenerateGo(t *testing.T) {
+	l := New(
+		&TokenType{Id: "IF", Pattern: "if\\b"},
+		&TokenType{Id: "ID", Pattern: "[a-z]+"},
+		&TokenType{Id: "SPC", Pattern: "\\s+"},
+	)
+	var src strings.Builder
+	if err := l.GenerateGo(&src, regex.GoOptions{Package: "calc", Name: "Calc"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"package calc", `"IF",`, `"ID",`, `"SPC",`, "func CalcNext(input string, previous rune) (int, int)"} {
+		if !strings.Contains(src.String(), s) {
+			t.Errorf("expected %q in generated source:\n%s", s, src.String())
+		}
+	}
+
+	lazy := New(&TokenType{Id: "COMMENT", Pattern: "/\\*(.|\\n)*?\\*/"})
+	if err := lazy.GenerateGo(&src, regex.GoOptions{Package: "calc", Name: "Calc"}); err == nil {
+		t.Error("expected an error for lazy quantifiers")
+	}
+}
+
+func TestLexerAnalyze(t *testing.T) {
+	l := New(
+		&TokenType{Id: "ID", Pattern: "[_a-zA-Z][_a-zA-Z0-9]*"},
+		&TokenType{Id: "LET", Pattern: "let"},
+		&TokenType{Id: "INT", Pattern: "[0-9]+"},
+		&TokenType{Id: "SPC", Pattern: "\\s*"},
+		&TokenType{Id: "NONE", Pattern: "[a-z]&[0-9]"},
+	)
+	a := l.Analyze()
+	if len(a.Shadowed) != 1 || a.Shadowed[0].TokenType.Id != "LET" || len(a.Shadowed[0].By) != 1 || a.Shadowed[0].By[0].Id != "ID" {
+		t.Errorf("expected LET to be shadowed by ID, got %v", a.Shadowed)
+	}
+	if len(a.Overlaps) != 1 || a.Overlaps[0].First.Id != "ID" || a.Overlaps[0].Second.Id != "LET" || a.Overlaps[0].Example != "let" {
+		t.Errorf("expected ID and LET to overlap on let, got %v", a.Overlaps)
+	}
+	if len(a.Empty) != 1 || a.Empty[0].Id != "SPC" {
+		t.Errorf("expected SPC to match the empty string, got %v", a.Empty)
+	}
+	if len(a.Unmatched) != 1 || a.Unmatched[0].Id != "NONE" {
+		t.Errorf("expected NONE to match nothing, got %v", a.Unmatched)
+	}
+	expected := "LET never produces a token: shadowed by ID\n" +
+		"NONE matches nothing\n" +
+		"ID and LET overlap, e.g., on \"let\"\n" +
+		"SPC matches the empty string\n"
+	if a.String() != expected {
+		t.Errorf("expected report:\n%s\ngot:\n%s", expected, a.String())
+	}
+
+	fixed := New(
+		&TokenType{Id: "LET", Pattern: "let"},
+		&TokenType{Id: "ID", Pattern: "[_a-zA-Z][_a-zA-Z0-9]*"},
+	)
+	if a := fixed.Analyze(); len(a.Shadowed) != 0 || len(a.Overlaps) != 1 {
+		t.Errorf("expected LET to win over ID, got %v", a)
+	}
+}
+
+func TestLexerMaximalMunch(t *testing.T) {
+	l := New(
+		&TokenType{Id: "FLOAT", Pattern: "\\d+\\.\\d+"},
+		&TokenType{Id: "INT", Pattern: "\\d+"},
+		&TokenType{Id: "RANGE", Pattern: "\\.\\.\\."},
+		&TokenType{Id: "DOT", Pattern: "\\."},
+		&TokenType{Id: "ID", Pattern: "[a-z]+"},
+		&TokenType{Id: "NL", Pattern: "\n"},
+	)
+	text := "1.x 1.5\n1..2 12345678.y"
+	expected := []Token{
+		{"INT", "1", 1, 1, nil, 0, 1, 2, 1},
+		{"DOT", ".", 1, 2, nil, 1, 1, 3, 2},
+		{"ID", "x", 1, 3, nil, 2, 1, 4, 3},
+	}
+	for _, size := range []int{8, 1024} {
+		l.Buffer(size)
+		var tokens []Token
+		for token, err := range l.LexTextSeq(text) {
+			if err != nil {
+				// ' ' is not a token
+				if !strings.HasPrefix(err.Error(), "error at 1:4:") {
+					t.Errorf("buffer %d: unexpected error %v", size, err)
+				}
+				break
+			}
+			tokens = append(tokens, token)
+		}
+		if !slices.Equal(tokens, expected) {
+			t.Errorf("buffer %d: expected %v, got %v", size, expected, tokens)
+		}
+	}
+
+	l = New(append(l.Definition, &TokenType{Id: "SPC", Pattern: " +"})...)
+	expected = []Token{
+		{"INT", "1", 1, 1, nil, 0, 1, 2, 1}, {"DOT", ".", 1, 2, nil, 1, 1, 3, 2}, {"ID", "x", 1, 3, nil, 2, 1, 4, 3}, {"SPC", " ", 1, 4, nil, 3, 1, 5, 4},
+		{"FLOAT", "1.5", 1, 5, nil, 4, 1, 8, 7}, {"NL", "\n", 1, 8, nil, 7, 2, 1, 8},
+		{"INT", "1", 2, 1, nil, 8, 2, 2, 9}, {"DOT", ".", 2, 2, nil, 9, 2, 3, 10}, {"DOT", ".", 2, 3, nil, 10, 2, 4, 11}, {"INT", "2", 2, 4, nil, 11, 2, 5, 12}, {"SPC", " ", 2, 5, nil, 12, 2, 6, 13},
+		{"INT", "12345678", 2, 6, nil, 13, 2, 14, 21}, {"DOT", ".", 2, 14, nil, 21, 2, 15, 22}, {"ID", "y", 2, 15, nil, 22, 2, 16, 23},
+		{EOF, "", 2, 16, nil, 23, 2, 16, 23},
+	}
+	for _, size := range []int{8, 1024} {
+		l.Buffer(size)
+		var tokens []Token
+		for token, err := range l.LexTextSeq(text) {
+			if err != nil {
+				t.Fatalf("buffer %d: %v", size, err)
+			}
+			tokens = append(tokens, token)
+		}
+		if !slices.Equal(tokens, expected) {
+			t.Errorf("buffer %d: expected %v, got %v", size, expected, tokens)
+		}
+	}
+}
+
+func TestLexerModes(t *testing.T) {
+	l := New(
+		NewTokenType("STR_START", "\"").In(DefaultMode, "interp").Push("string"),
+		NewTokenType("STR_TEXT", "[^\"$]+").In("string"),
+		NewTokenType("INTERP_START", "\\$\\{").In("string").Push("interp"),
+		NewTokenType("STR_END", "\"").In("string").Pop(),
+		NewTokenType("RBRACE", "\\}").In("interp").Pop(),
+		NewTokenType("COMMENT_START", "/\\*").In(DefaultMode, "comment").Push("comment"),
+		NewTokenType("COMMENT_END", "\\*/").In("comment").Pop(),
+		NewTokenType("COMMENT_TEXT", "[^*/]+|\\*|/").In("comment"),
+		NewTokenType("ID", "[a-z]+").In(DefaultMode, "interp"),
+		NewTokenType("SPC", "\\s+").In(DefaultMode, "interp"),
+	)
+	expected := []struct{ typ, text, modes string }{
+		{"ID", "x", "default"},
+		{"SPC", " ", "default"},
+		{"STR_START", "\"", "default"},
+		{"STR_TEXT", "a ", "default/string"},
+		{"INTERP_START", "${", "default/string"},
+		{"ID", "y", "default/string/interp"},
+		{"SPC", " ", "default/string/interp"},
+		{"STR_START", "\"", "default/string/interp"},
+		{"STR_TEXT", "b", "default/string/interp/string"},
+		{"STR_END", "\"", "default/string/interp/string"},
+		{"RBRACE", "}", "default/string/interp"},
+		{"STR_TEXT", "c", "default/string"},
+		{"STR_END", "\"", "default/string"},
+		{"SPC", " ", "default"},
+		{"COMMENT_START", "/*", "default"},
+		{"COMMENT_TEXT", " p ", "default/comment"},
+		{"COMMENT_START", "/*", "default/comment"},
+		{"COMMENT_TEXT", " q ", "default/comment/comment"},
+		{"COMMENT_END", "*/", "default/comment/comment"},
+		{"COMMENT_TEXT", " r ", "default/comment"},
+		{"COMMENT_END", "*/", "default/comment"},
+		{"SPC", " ", "default"},
+		{"ID", "z", "default"},
+		{EOF, "", "default"},
+	}
+	check := func(name string, l *Lexer) []Token {
+		var tokens []Token
+		for token, err := range l.LexTextSeq("x \"a ${y \"b\"}c\" /* p /* q */ r */ z") {
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			tokens = append(tokens, token)
+		}
+		if len(tokens) != len(expected) {
+			t.Fatalf("%s: expected %d tokens, got %v", name, len(expected), tokens)
+		}
+		for i, e := range expected {
+			if tokens[i].Type != e.typ || tokens[i].Text != e.text || tokens[i].Modes.String() != e.modes {
+				t.Errorf("%s: token %d: expected %v, got %v in %s", name, i, e, tokens[i], tokens[i].Modes)
+			}
+		}
+		return tokens
+	}
+	tokens := check("lexer", l)
+	if tokens[0].Modes != nil || tokens[3].Modes != tokens[12].Modes || tokens[15].Modes.Top() != "comment" {
+		t.Error("expected tokens in the same modes to share their mode stack")
+	}
+
+	// modes and actions are encoded
+	data, err := l.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var binaryLoaded Lexer
+	if err := binaryLoaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	check("binary", &binaryLoaded)
+	data, err = json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsonLoaded Lexer
+	if err := json.Unmarshal(data, &jsonLoaded); err != nil {
+		t.Fatal(err)
+	}
+	check("json", &jsonLoaded)
+
+	// the sets of the modes are loaded, not built again
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	var sets map[string]json.RawMessage
+	if err := json.Unmarshal(fields["modes"], &sets); err != nil || len(sets) != 4 {
+		t.Fatalf("expected the sets of the default, string, interp and comment modes, got %v, %v", sets, err)
+	}
+	delete(fields, "modes")
+	if data, err = json.Marshal(fields); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &jsonLoaded); !errors.Is(err, regex.ErrEncoding) || !strings.Contains(err.Error(), "mode default") {
+		t.Error("expected an encoding error without the sets of the modes, got", err)
+	}
+
+	// token types in different modes do not shadow each other
+	if a := l.Analyze(); len(a.Shadowed) != 0 || len(a.Overlaps) != 0 {
+		t.Errorf("expected no shadowing or overlap, got %v", a)
+	}
+
+	var src strings.Builder
+	if err := l.GenerateGo(&src, regex.GoOptions{Package: "p", Name: "L"}); err == nil {
+		t.Error("expected an error generating a lexer with modes")
+	}
+}
+
+func TestModeActions(t *testing.T) {
+	stacks := modeStacks{}
+	tests := []struct {
+		actions []ModeAction
+		modes   string
+	}{
+		{nil, "default"},
+		{[]ModeAction{{PopMode, ""}}, "default"},
+		{[]ModeAction{{SwitchMode, "a"}}, "default/a"},
+		{[]ModeAction{{PushMode, "a"}, {SwitchMode, "b"}}, "default/b"},
+		{[]ModeAction{{PushMode, "a"}, {PushMode, "b"}, {PopMode, ""}}, "default/a"},
+		{[]ModeAction{{SwitchMode, "a"}, {SwitchMode, DefaultMode}}, "default"},
+		{[]ModeAction{{PushMode, "a"}, {PushMode, DefaultMode}}, "default/a/default"},
+	}
+	for _, test := range tests {
+		if s := stacks.apply(nil, test.actions); s.String() != test.modes {
+			t.Errorf("%v: expected %s, got %s", test.actions, test.modes, s)
+		}
+	}
+	if stacks.apply(nil, []ModeAction{{SwitchMode, DefaultMode}}) != nil {
+		t.Error("expected switching to the default mode to give the empty stack")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a mode with no token types")
+		}
+	}()
+	New(NewTokenType("A", "a").Push("none"))
+}
+
+func TestLexerRecover(t *testing.T) {
+	l := New(
+		&TokenType{Id: "ID", Pattern: "[a-z]+"},
+		&TokenType{Id: "INT", Pattern: "[0-9]+"},
+		&TokenType{Id: "RANGE", Pattern: "\\.\\.\\."},
+		&TokenType{Id: "SPC", Pattern: " +"},
+	)
+	text := "ab #$ 12 ..x ?"
+	expected := []Token{
+		{"ID", "ab", 1, 1, nil, 0, 1, 3, 2},
+		{"SPC", " ", 1, 3, nil, 2, 1, 4, 3},
+		{Error, "#$", 1, 4, nil, 3, 1, 6, 5},
+		{"SPC", " ", 1, 6, nil, 5, 1, 7, 6},
+		{"INT", "12", 1, 7, nil, 6, 1, 9, 8},
+		{"SPC", " ", 1, 9, nil, 8, 1, 10, 9},
+		{Error, ".", 1, 10, nil, 9, 1, 11, 10},
+		{Error, ".", 1, 11, nil, 10, 1, 12, 11},
+		{"ID", "x", 1, 12, nil, 11, 1, 13, 12},
+		{"SPC", " ", 1, 13, nil, 12, 1, 14, 13},
+		{Error, "?", 1, 14, nil, 13, 1, 15, 14},
+		{EOF, "", 1, 15, nil, 14, 1, 15, 14},
+	}
+	errorsAt := []string{"error at 1:4:", "error at 1:12:", "error at 1:12:", "error at 1:14:"}
+
+	lex := func(recovering bool) ([]Token, []error) {
+		var tokens []Token
+		var errs []error
+		for token, err := range l.LexTextSeq(text) {
+			tokens = append(tokens, token)
+			if err != nil {
+				if recovering && token.Type != Error {
+					t.Errorf("expected an error token with %v, got %v", err, token)
+				}
+				errs = append(errs, err)
+			}
+		}
+		return tokens, errs
+	}
+
+	// without recovery, lexing stops at the first error
+	if tokens, errs := lex(false); len(tokens) != 3 || len(errs) != 1 {
+		t.Errorf("expected lexing to stop at the first error, got %v, %v", tokens, errs)
+	}
+
+	for _, size := range []int{8, 1024} {
+		l.Buffer(size)
+		l.Recover(0)
+		tokens, errs := lex(true)
+		if !slices.Equal(tokens, expected) {
+			t.Errorf("buffer %d: expected %v, got %v", size, expected, tokens)
+		}
+		if len(errs) != len(errorsAt) {
+			t.Fatalf("buffer %d: expected %d errors, got %v", size, len(errorsAt), errs)
+		}
+		for i, err := range errs {
+			if !strings.HasPrefix(err.Error(), errorsAt[i]) {
+				t.Errorf("buffer %d: expected %s, got %v", size, errorsAt[i], err)
+			}
+		}
+	}
+
+	// lexing stops after the maximum number of errors
+	l.Recover(2)
+	if tokens, errs := lex(true); !slices.Equal(tokens, expected[:7]) || len(errs) != 2 {
+		t.Errorf("expected lexing to stop at the second error, got %v, %v", tokens, errs)
+	}
+}
+
+func TestLexError(t *testing.T) {
+	l := New(
+		&TokenType{Id: "ID", Pattern: "\\p{L}+"},
+		&TokenType{Id: "FLOAT", Pattern: "[0-9]+\\.[0-9]+"},
+		&TokenType{Id: "SPC", Pattern: "[ \n]+"},
+	)
+	lexError := func(input string) *LexError {
+		for _, err := range l.LexTextSeq(input) {
+			if err != nil {
+				var lexErr *LexError
+				if !errors.As(err, &lexErr) {
+					t.Fatalf("%q: expected a LexError, got %v", input, err)
+				}
+				return lexErr
+			}
+		}
+		t.Fatalf("%q: expected an error", input)
+		return nil
+	}
+
+	err := lexError("αβ 12.x")
+	if err.Line != 1 || err.Column != 7 || err.Offset != 8 || err.Rune != 'x' || err.Text != "12." {
+		t.Errorf("unexpected error position, character or text: %+v", err)
+	}
+	if len(err.Partial) != 1 || err.Partial[0].TokenType.Id != "FLOAT" {
+		t.Fatalf("expected FLOAT to be partially matched, got %+v", err.Partial)
+	}
+	if e := err.Partial[0].Expected; len(e) != 1 || e[0].Pattern != "[0-9]" || !e[0].Contains('7') {
+		t.Errorf("expected a digit after 12., got %v", e)
+	}
+	expected := "error at 1:7: potential partial match(es): FLOAT (next expected character(s): [0-9])"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+
+	err = lexError("ab\n3.")
+	if err.Line != 2 || err.Column != 3 || err.Offset != 5 || err.Rune != -1 || err.Text != "3." {
+		t.Errorf("unexpected error at the end of the input: %+v", err)
+	}
+
+	l.Recover(0)
+	err = lexError("αβ 12.x")
+	if err.Column != 7 || err.Text != "12." {
+		t.Errorf("expected the same error when recovering, got %+v", err)
+	}
+}
+
+func TestTokenSpans(t *testing.T) {
+	l := New(
+		&TokenType{Id: "WORD", Pattern: "[^ \t\n#]+"},
+		&TokenType{Id: "SPC", Pattern: "[ \t\n]+"},
+	)
+	text := "a\tβ😀x\n\tcd"
+	tests := []struct {
+		unit     ColumnUnit
+		tabWidth int
+		expected []Token
+	}{
+		{RuneColumns, 0, []Token{
+			{"WORD", "a", 1, 1, nil, 0, 1, 2, 1},
+			{"SPC", "\t", 1, 2, nil, 1, 1, 3, 2},
+			{"WORD", "β😀x", 1, 3, nil, 2, 1, 6, 9},
+			{"SPC", "\n\t", 1, 6, nil, 9, 2, 2, 11},
+			{"WORD", "cd", 2, 2, nil, 11, 2, 4, 13},
+			{EOF, "", 2, 4, nil, 13, 2, 4, 13},
+		}},
+		{RuneColumns, 4, []Token{
+			{"WORD", "a", 1, 1, nil, 0, 1, 2, 1},
+			{"SPC", "\t", 1, 2, nil, 1, 1, 5, 2},
+			{"WORD", "β😀x", 1, 5, nil, 2, 1, 8, 9},
+			{"SPC", "\n\t", 1, 8, nil, 9, 2, 5, 11},
+			{"WORD", "cd", 2, 5, nil, 11, 2, 7, 13},
+			{EOF, "", 2, 7, nil, 13, 2, 7, 13},
+		}},
+		{ByteColumns, 4, []Token{
+			{"WORD", "a", 1, 1, nil, 0, 1, 2, 1},
+			{"SPC", "\t", 1, 2, nil, 1, 1, 5, 2},
+			{"WORD", "β😀x", 1, 5, nil, 2, 1, 12, 9},
+			{"SPC", "\n\t", 1, 12, nil, 9, 2, 5, 11},
+			{"WORD", "cd", 2, 5, nil, 11, 2, 7, 13},
+			{EOF, "", 2, 7, nil, 13, 2, 7, 13},
+		}},
+		{UTF16Columns, 4, []Token{
+			{"WORD", "a", 1, 1, nil, 0, 1, 2, 1},
+			{"SPC", "\t", 1, 2, nil, 1, 1, 5, 2},
+			{"WORD", "β😀x", 1, 5, nil, 2, 1, 9, 9},
+			{"SPC", "\n\t", 1, 9, nil, 9, 2, 5, 11},
+			{"WORD", "cd", 2, 5, nil, 11, 2, 7, 13},
+			{EOF, "", 2, 7, nil, 13, 2, 7, 13},
+		}},
+	}
+	for _, test := range tests {
+		l.Columns(test.unit)
+		l.TabWidth(test.tabWidth)
+		var tokens []Token
+		for token := range l.LexTextSeq(text) {
+			tokens = append(tokens, token)
+		}
+		if !slices.Equal(tokens, test.expected) {
+			t.Errorf("unit %d, tab width %d: expected %v, got %v", test.unit, test.tabWidth, test.expected, tokens)
+		}
+	}
+
+	word := Token{"WORD", "β😀x", 1, 5, nil, 2, 1, 9, 9}
+	if s := word.Span(); s != (Span{Position{1, 5, 2}, Position{1, 9, 9}}) {
+		t.Errorf("unexpected span %v", s)
+	}
+	if s := text[word.Span().Start.Offset:word.Span().End.Offset]; s != word.Text {
+		t.Errorf("expected the span to cover %q, got %q", word.Text, s)
+	}
+
+	// errors are positioned in the same units
+	for _, err := range l.LexTextSeq("😀#") {
+		var lexErr *LexError
+		if err != nil && (!errors.As(err, &lexErr) || lexErr.Column != 3 || lexErr.Offset != 4) {
+			t.Errorf("expected an error at column 3 and offset 4, got %v", err)
+		}
+	}
+
+	// an invalid byte is read as utf8.RuneError but counts as the one byte read
+	l.Columns(ByteColumns)
+	var tokens []Token
+	for token := range l.LexTextSeq("a\xffb c") {
+		tokens = append(tokens, token)
+	}
+	expected := []Token{
+		{"WORD", "a�b", 1, 1, nil, 0, 1, 4, 3},
+		{"SPC", " ", 1, 4, nil, 3, 1, 5, 4},
+		{"WORD", "c", 1, 5, nil, 4, 1, 6, 5},
+		{EOF, "", 1, 6, nil, 5, 1, 6, 5},
+	}
+	if !slices.Equal(tokens, expected) {
+		t.Errorf("expected %v, got %v", expected, tokens)
+	}
+	if s := tokens[0].Span(); s != (Span{Position{1, 1, 0}, Position{1, 4, 3}}) {
+		t.Errorf("unexpected span %v of invalid UTF-8", s)
+	}
+}
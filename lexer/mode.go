@@ -0,0 +1,216 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package lexer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/vikashmadhow/prefix_regex_matcher/regex"
+)
+
+const (
+	// DefaultMode is the mode in which lexing starts, at the bottom of the mode stack,
+	// and the mode of the token types not tagged with any mode.
+	DefaultMode = "default"
+
+	// AllModes tags a token type as active in all the modes of the lexer.
+	AllModes = "*"
+)
+
+// ModeOp is the operation of a ModeAction on the mode stack.
+type ModeOp int
+
+const (
+	// PushMode enters a mode, which is left by PopMode.
+	PushMode ModeOp = iota
+
+	// PopMode leaves the current mode, returning to the mode below it in the stack.
+	// Popping the default mode leaves it in place.
+	PopMode
+
+	// SwitchMode replaces the current mode by another, which is pushed over the
+	// default mode if it is the current mode.
+	SwitchMode
+)
+
+type (
+	// ModeAction is an operation on the mode stack of the lexer done when a token is
+	// produced, similar to flex BEGIN and yy_push_state or ANTLR pushMode, popMode and
+	// mode commands.
+	ModeAction struct {
+		Op   ModeOp
+		Mode string
+	}
+
+	// ModeStack is an immutable stack of lexer modes above the default mode, which is
+	// always at its bottom: nil is the stack with only the default mode. Tokens with the
+	// same mode stack in the same token sequence share the same *ModeStack, keeping
+	// tokens comparable.
+	ModeStack struct {
+		Mode   string
+		Parent *ModeStack
+	}
+
+	// mode is the set of the patterns of the token types active in a mode, with the
+	// index in the definition of the lexer of the token type of each pattern.
+	mode struct {
+		set   *regex.RegexSet
+		types []int
+	}
+
+	// modeKey identifies a mode stack for sharing it.
+	modeKey struct {
+		mode   string
+		parent *ModeStack
+	}
+)
+
+// In sets the modes in which the token type is active, instead of the default mode,
+// and returns the token type.
+func (t *TokenType) In(modes ...string) *TokenType {
+	t.Modes = modes
+	return t
+}
+
+// Push adds an action pushing the mode when the token type produces a token, and
+// returns the token type.
+func (t *TokenType) Push(mode string) *TokenType {
+	t.Actions = append(t.Actions, ModeAction{PushMode, mode})
+	return t
+}
+
+// Pop adds an action leaving the current mode when the token type produces a token,
+// and returns the token type.
+func (t *TokenType) Pop() *TokenType {
+	t.Actions = append(t.Actions, ModeAction{PopMode, ""})
+	return t
+}
+
+// Switch adds an action replacing the current mode by the mode when the token type
+// produces a token, and returns the token type.
+func (t *TokenType) Switch(mode string) *TokenType {
+	t.Actions = append(t.Actions, ModeAction{SwitchMode, mode})
+	return t
+}
+
+// activeIn returns true if the token type is active in the mode.
+func (t *TokenType) activeIn(mode string) bool {
+	if len(t.Modes) == 0 {
+		return mode == DefaultMode
+	}
+	return slices.Contains(t.Modes, mode) || slices.Contains(t.Modes, AllModes)
+}
+
+// Top returns the current mode, at the top of the stack.
+func (s *ModeStack) Top() string {
+	if s == nil {
+		return DefaultMode
+	}
+	return s.Mode
+}
+
+// Modes returns the modes in the stack, from the default mode at the bottom to the
+// current mode.
+func (s *ModeStack) Modes() []string {
+	var modes []string
+	for ; s != nil; s = s.Parent {
+		modes = append(modes, s.Mode)
+	}
+	modes = append(modes, DefaultMode)
+	slices.Reverse(modes)
+	return modes
+}
+
+// String returns the modes in the stack from the bottom, separated by '/'.
+func (s *ModeStack) String() string {
+	return strings.Join(s.Modes(), "/")
+}
+
+// modeStacks shares the mode stacks of a token sequence so that equal stacks are the
+// same pointer.
+type modeStacks map[modeKey]*ModeStack
+
+// apply returns the stack after the actions.
+func (stacks modeStacks) apply(s *ModeStack, actions []ModeAction) *ModeStack {
+	for _, a := range actions {
+		switch a.Op {
+		case PushMode:
+			s = stacks.push(a.Mode, s)
+		case PopMode:
+			if s != nil {
+				s = s.Parent
+			}
+		case SwitchMode:
+			if s != nil {
+				s = s.Parent
+			}
+			if s != nil || a.Mode != DefaultMode {
+				s = stacks.push(a.Mode, s)
+			}
+		}
+	}
+	return s
+}
+
+func (stacks modeStacks) push(mode string, parent *ModeStack) *ModeStack {
+	key := modeKey{mode, parent}
+	s, ok := stacks[key]
+	if !ok {
+		s = &ModeStack{mode, parent}
+		stacks[key] = s
+	}
+	return s
+}
+
+// buildModes groups the token types of the lexer by the modes in which they are
+// active, the order of the definition giving their priority in every mode. A mode with
+// all the token types uses the set of all of them; the sets of the other modes are
+// taken from sets, for a decoded lexer, or built if sets is nil.
+func (lexer *Lexer) buildModes(sets map[string]*regex.RegexSet) error {
+	names := []string{DefaultMode}
+	for _, d := range lexer.Definition {
+		for _, m := range d.Modes {
+			if m != AllModes && !slices.Contains(names, m) {
+				names = append(names, m)
+			}
+		}
+		for _, a := range d.Actions {
+			if a.Op != PopMode && !slices.Contains(names, a.Mode) {
+				names = append(names, a.Mode)
+			}
+		}
+	}
+	lexer.modes = map[string]*mode{}
+	for _, name := range names {
+		m := &mode{}
+		var compiled []*regex.CompiledRegex
+		for i, d := range lexer.Definition {
+			if d.activeIn(name) {
+				m.types = append(m.types, i)
+				compiled = append(compiled, d.Compiled)
+			}
+		}
+		if len(m.types) == 0 && name != DefaultMode {
+			return fmt.Errorf("no token type is active in mode %s", name)
+		}
+		if len(m.types) == len(lexer.Definition) {
+			m.set = lexer.set
+		} else if sets != nil {
+			set := sets[name]
+			if set == nil || len(set.Regexes) != len(m.types) {
+				return fmt.Errorf("no set of the %d token types of mode %s", len(m.types), name)
+			}
+			m.set = set
+		} else {
+			set, err := regex.SetOf(compiled...)
+			if err != nil {
+				return err
+			}
+			m.set = set
+		}
+		lexer.modes[name] = m
+	}
+	return nil
+}
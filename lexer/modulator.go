@@ -25,7 +25,7 @@ func Ignore(types ...string) Modulator {
 		if _, ok := ignore[t.Type]; ok {
 			return nil
 		}
-		return []seq.Pair[Token, error]{{t, e}}
+		return []seq.Pair[Token, error]{{A: t, B: e}}
 	}
 }
 
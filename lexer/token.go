@@ -13,12 +13,41 @@ type (
 		Text   string
 		Line   int
 		Column int
+
+		// Modes is the mode stack of the lexer when the token was matched, nil in the
+		// default mode.
+		Modes *ModeStack
+
+		// Offset is the position of the token in bytes from the start of the input, and
+		// EndLine, EndColumn and EndOffset the position following it. Invalid UTF-8
+		// bytes, which are in Text as utf8.RuneError, count as one byte each.
+		Offset                        int
+		EndLine, EndColumn, EndOffset int
+	}
+
+	// Position is a position in the input: a line and a column starting at 1, and an
+	// offset in bytes from the start of the input.
+	Position struct {
+		Line, Column, Offset int
+	}
+
+	// Span is the part of the input from Start to End, excluded.
+	Span struct {
+		Start, End Position
 	}
 
 	TokenType struct {
 		Id       string
 		Pattern  string
 		Compiled *regex.CompiledRegex
+
+		// Modes are the modes in which the token type is active, the default mode if
+		// empty.
+		Modes []string
+
+		// Actions are done in order on the mode stack when the token type produces a
+		// token.
+		Actions []ModeAction
 	}
 
 	TokenSeq struct {
@@ -27,24 +56,63 @@ type (
 		//pushedBack []*Token
 		pushedBack chan *Token
 	}
-
-	TokenMatcher struct {
-		def     *TokenType
-		matcher *regex.Matcher
-	}
 )
 
 var (
 	Empty = "∅"
 	EOF   = "Ω"
+
+	// Error is the type of the tokens of unrecognised input produced by a lexer
+	// recovering from errors.
+	Error = "ERROR"
 )
 
+// ColumnUnit is the unit in which a lexer counts columns.
+type ColumnUnit int
+
+const (
+	// RuneColumns counts a column per character.
+	RuneColumns ColumnUnit = iota
+
+	// ByteColumns counts a column per byte of the UTF-8 encoding of characters.
+	ByteColumns
+
+	// UTF16Columns counts a column per UTF-16 code unit of characters, as the
+	// positions of the Language Server Protocol (which start at 0 instead of 1).
+	UTF16Columns
+)
+
+// Span returns the part of the input covered by the token.
+func (t Token) Span() Span {
+	return Span{
+		Position{t.Line, t.Column, t.Offset},
+		Position{t.EndLine, t.EndColumn, t.EndOffset},
+	}
+}
+
 func SimpleTokenType(id string) *TokenType {
 	return NewTokenType(id, regex.Escape(id))
 }
 
 func NewTokenType(id string, pattern string) *TokenType {
-	return &TokenType{id, pattern, regex.NewRegex(pattern)}
+	return &TokenType{Id: id, Pattern: pattern, Compiled: regex.MustCompile(pattern)}
+}
+
+// Parts returns the text of the named capture groups of the token type's pattern in
+// the token, by name, or nil if the token text does not match the pattern. Named
+// groups which did not take part in the match are absent.
+func (t *TokenType) Parts(token Token) map[string]string {
+	named := t.Compiled.NamedSubmatches(token.Text)
+	if named == nil {
+		return nil
+	}
+	parts := map[string]string{}
+	for name, loc := range named {
+		if loc != nil {
+			parts[name] = loc.Text
+		}
+	}
+	return parts
 }
 
 func (t *TokenSeq) Next() (*Token, error, bool) {
@@ -0,0 +1,559 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"container/list"
+	"slices"
+	"unicode/utf8"
+)
+
+// Intersections (re&re) and complements (~re) are built on the DFAs of their operands
+// by product construction and embedded in the NFA of the enclosing regular expression
+// as a copy of the resulting DFA, which is also a valid NFA. The operands cannot have
+// assertions (their DFAs would depend on the context of the position) and their
+// capture groups do not capture, as a DFA has no paths to take them from.
+
+type (
+	// intersection is for the strings matched by both regular expressions (re&re).
+	intersection struct {
+		left, right Regex
+
+		// dfa is the minimal DFA of the intersection, built by prepare or on first use.
+		dfa *automata
+	}
+
+	// complement is for the strings not matched by the regular expression (~re).
+	complement struct {
+		re Regex
+
+		// dfa is the minimal DFA of the complement, built by prepare or on first use.
+		dfa *automata
+	}
+)
+
+// The methods Intersect, Union, Complement and Difference combine the parsed regular
+// expressions of their operands, which are not parsed again, and compile the result
+// with the configuration of the receiver. The capture groups of the result are those of
+// the receiver followed by those of other, with their names, which may then repeat;
+// only those of a union capture. The pattern of the result, which is encoded by
+// MarshalBinary, is written from the patterns of the operands and cannot be encoded if
+// it repeats a group name.
+
+// Intersect returns the regular expression matching the strings matched by both this
+// regular expression and other, i.e., (?:r)&(?:other), or a *SyntaxError if one of them
+// has assertions.
+func (r *CompiledRegex) Intersect(other *CompiledRegex) (*CompiledRegex, error) {
+	source := "(?:" + r.source + ")&(?:" + other.source + ")"
+	if err := withoutAssertions(source, 3, r, "intersection"); err != nil {
+		return nil, err
+	}
+	if err := withoutAssertions(source, len(r.source)+8, other, "intersection"); err != nil {
+		return nil, err
+	}
+	return r.combine(source, &intersection{left: &group{re: r.Regex}, right: &group{re: other.Regex}}, other)
+}
+
+// Union returns the regular expression matching the strings matched by this regular
+// expression or other, i.e., (?:r)|(?:other).
+func (r *CompiledRegex) Union(other *CompiledRegex) (*CompiledRegex, error) {
+	source := "(?:" + r.source + ")|(?:" + other.source + ")"
+	right := shiftGroups(other.Regex, len(r.names)-1)
+	return r.combine(source, &choice{left: &group{re: r.Regex}, right: &group{re: right}}, other)
+}
+
+// Complement returns the regular expression matching the strings not matched by this
+// regular expression, i.e., ~(?:r), or a *SyntaxError if it has assertions.
+func (r *CompiledRegex) Complement() (*CompiledRegex, error) {
+	source := "~(?:" + r.source + ")"
+	if err := withoutAssertions(source, 4, r, "complement"); err != nil {
+		return nil, err
+	}
+	return r.combine(source, &complement{re: &group{re: r.Regex}}, nil)
+}
+
+// Difference returns the regular expression matching the strings matched by this
+// regular expression and not by other, i.e., (?:r)&~(?:other), or a *SyntaxError if
+// one of them has assertions.
+func (r *CompiledRegex) Difference(other *CompiledRegex) (*CompiledRegex, error) {
+	source := "(?:" + r.source + ")&~(?:" + other.source + ")"
+	if err := withoutAssertions(source, 3, r, "intersection"); err != nil {
+		return nil, err
+	}
+	if err := withoutAssertions(source, len(r.source)+9, other, "complement"); err != nil {
+		return nil, err
+	}
+	return r.combine(source, &intersection{left: &group{re: r.Regex}, right: &complement{re: &group{re: other.Regex}}}, other)
+}
+
+// combine compiles the regular expression combining this one with other (nil for a
+// complement), written as source, with the configuration of this one.
+func (r *CompiledRegex) combine(source string, re Regex, other *CompiledRegex) (*CompiledRegex, error) {
+	names := slices.Clone(r.names)
+	if other != nil {
+		names = append(names, other.names[1:]...)
+	}
+	compiled, err := r.config.compile(re, names)
+	if err != nil {
+		return nil, err
+	}
+	compiled.source = source
+	return compiled, nil
+}
+
+// withoutAssertions returns a *SyntaxError if the operand of the operation, at the byte
+// offset in the pattern of their combination, has assertions, as the DFAs of
+// intersections and complements cannot depend on the context of a position.
+func withoutAssertions(pattern string, offset int, operand *CompiledRegex, operation string) error {
+	if len(operand.Nfa.assertions()) > 0 {
+		return &SyntaxError{pattern, utf8.RuneCountInString(pattern[:offset]), operand.source, "assertion in " + operation, "an expression without assertions"}
+	}
+	return nil
+}
+
+// shiftGroups returns a copy of the regular expression with its capture groups
+// numbered after offset, including on the characters they contain. Intersections and
+// complements are shared as their groups do not capture.
+func shiftGroups(r Regex, offset int) Regex {
+	switch r := r.(type) {
+	case *choice:
+		return &choice{shiftGroups(r.left, offset), shiftGroups(r.right, offset)}
+	case *sequence:
+		s := &sequence{make([]Regex, len(r.sequence))}
+		for i, re := range r.sequence {
+			s.sequence[i] = shiftGroups(re, offset)
+		}
+		return s
+	case *zeroOrOne:
+		return &zeroOrOne{shiftGroups(r.opt, offset), r.lazy}
+	case *zeroOrMore:
+		return &zeroOrMore{shiftGroups(r.re, offset), r.lazy}
+	case *oneOrMore:
+		return &oneOrMore{shiftGroups(r.re, offset), r.lazy}
+	case *repeat:
+		return &repeat{shiftGroups(r.re, offset), r.min, r.max, r.lazy}
+	case *captureGroup:
+		return &captureGroup{shiftGroups(r.re, offset), r.index + offset, r.name}
+	case *group:
+		return &group{shiftGroups(r.re, offset), r.flags}
+	case *anyChar:
+		c := *r
+		return shiftCharGroups(&c, offset)
+	case *singleChar:
+		c := *r
+		return shiftCharGroups(&c, offset)
+	case *charRange:
+		c := *r
+		return shiftCharGroups(&c, offset)
+	case *charSet:
+		c := *r
+		return shiftCharGroups(&c, offset)
+	case *setOperation:
+		c := *r
+		return shiftCharGroups(&c, offset)
+	case *unicodeClass:
+		c := *r
+		return shiftCharGroups(&c, offset)
+	case *inList:
+		c := *r
+		return shiftCharGroups(&c, offset)
+	}
+	return r
+}
+
+// shiftCharGroups numbers the capture groups containing the character, a copy, after
+// offset, and returns it.
+func shiftCharGroups(c char, offset int) char {
+	groups := list.New()
+	for g := c.groups().Front(); g != nil; g = g.Next() {
+		if index := g.Value.(int); index > 0 {
+			groups.PushBack(index + offset)
+		} else {
+			groups.PushBack(index)
+		}
+	}
+	c.setGroups(groups)
+	return c
+}
+
+func (r *intersection) Pattern() string {
+	return r.left.Pattern() + "&" + r.right.Pattern()
+}
+
+func (r *intersection) nfa() *automata {
+	if r.dfa == nil {
+		r.dfa, _ = r.build(0)
+	}
+	return embedDfa(r.dfa)
+}
+
+func (r *intersection) emit(p *program, next int) int {
+	if r.dfa == nil {
+		r.dfa, _ = r.build(0)
+	}
+	return emitDfa(r.dfa, p, next)
+}
+
+// build returns the minimal DFA of the intersection, or a *SizeError if it or the DFA
+// of an operand has more than limit states.
+func (r *intersection) build(limit int) (*automata, error) {
+	left, err := operand(r.left, limit)
+	if err != nil {
+		return nil, err
+	}
+	right, err := operand(r.right, limit)
+	if err != nil {
+		return nil, err
+	}
+	d := product(left, right, true, limit)
+	if d == nil {
+		return nil, &SizeError{r.Pattern(), limit}
+	}
+	return d.minimise(), nil
+}
+
+func (r *complement) Pattern() string {
+	return "~" + r.re.Pattern()
+}
+
+func (r *complement) nfa() *automata {
+	if r.dfa == nil {
+		r.dfa, _ = r.build(0)
+	}
+	return embedDfa(r.dfa)
+}
+
+func (r *complement) emit(p *program, next int) int {
+	if r.dfa == nil {
+		r.dfa, _ = r.build(0)
+	}
+	return emitDfa(r.dfa, p, next)
+}
+
+// build returns the minimal DFA of the complement, the difference between the DFA
+// accepting all strings and the DFA of the operand, or a *SizeError if it or the DFA
+// of the operand has more than limit states.
+func (r *complement) build(limit int) (*automata, error) {
+	re, err := operand(r.re, limit)
+	if err != nil {
+		return nil, err
+	}
+	all := &automata{Trans: make(transitions), start: newState()}
+	all.final = []state{all.start}
+	every := &charClass{spans: allUnicode, sources: []char{&anyChar{mod: &modifier{}}}}
+	all.addTransitions(all.start, map[char]state{every: all.start})
+	d := product(all, re, false, limit)
+	if d == nil {
+		return nil, &SizeError{r.Pattern(), limit}
+	}
+	return d.minimise(), nil
+}
+
+// prepare builds the DFAs of the intersections and complements in the regular
+// expression, innermost first, returning a *SizeError if one of them exceeds the
+// limit on the number of states (none if 0).
+func prepare(r Regex, limit int) error {
+	switch r := r.(type) {
+	case *choice:
+		if err := prepare(r.left, limit); err != nil {
+			return err
+		}
+		return prepare(r.right, limit)
+	case *sequence:
+		for _, re := range r.sequence {
+			if err := prepare(re, limit); err != nil {
+				return err
+			}
+		}
+	case *zeroOrOne:
+		return prepare(r.opt, limit)
+	case *zeroOrMore:
+		return prepare(r.re, limit)
+	case *oneOrMore:
+		return prepare(r.re, limit)
+	case *repeat:
+		return prepare(r.re, limit)
+	case *captureGroup:
+		return prepare(r.re, limit)
+	case *group:
+		return prepare(r.re, limit)
+	case *intersection:
+		if r.dfa == nil {
+			d, err := r.build(limit)
+			if err != nil {
+				return err
+			}
+			r.dfa = d
+		}
+	case *complement:
+		if r.dfa == nil {
+			d, err := r.build(limit)
+			if err != nil {
+				return err
+			}
+			r.dfa = d
+		}
+	}
+	return nil
+}
+
+// operand returns the minimal DFA of an operand of an intersection or complement, or
+// a *SizeError if it has more than limit states.
+func operand(r Regex, limit int) (*automata, error) {
+	if err := prepare(r, limit); err != nil {
+		return nil, err
+	}
+	if limit > 0 && nfaSize(r) > limit {
+		return nil, &SizeError{r.Pattern(), limit}
+	}
+	d := r.nfa().dfaWithin(limit)
+	if d == nil {
+		return nil, &SizeError{r.Pattern(), limit}
+	}
+	return d.minimise(), nil
+}
+
+// product returns the DFA of the pairs of states of the DFAs a and b, accepting the
+// strings accepted by both if intersect, or by a and not by b otherwise, or nil if it
+// has more than limit states (no limit if 0). A state of b missing from a pair is the
+// dead state, accepting nothing. Transitions on characters used only for generation
+// are dropped.
+func product(a, b *automata, intersect bool, limit int) *automata {
+	type pair struct{ a, b state }
+	finalA, finalB := set[state]{}, set[state]{}
+	for _, f := range a.final {
+		finalA[f] = true
+	}
+	for _, f := range b.final {
+		finalB[f] = true
+	}
+
+	d := &automata{Trans: make(transitions), final: []state{}}
+	states := map[pair]state{}
+	var pending []pair
+	target := func(p pair) state {
+		s, ok := states[p]
+		if !ok {
+			s = newState()
+			states[p] = s
+			pending = append(pending, p)
+			if finalA[p.a] && finalB[p.b] == intersect {
+				d.final = append(d.final, s)
+			}
+		}
+		return s
+	}
+	d.start = target(pair{a.start, b.start})
+
+	for len(pending) > 0 {
+		if limit > 0 && len(states) > limit {
+			return nil
+		}
+		p := pending[0]
+		pending = pending[1:]
+
+		// the classes of characters on the transitions of both states, those of a
+		// coming first
+		var moves []move
+		var sets []spanSet
+		add := func(d *automata, s state) {
+			for c, t := range d.Trans[s] {
+				if cc, ok := c.(*charClass); ok {
+					moves = append(moves, move{cc, t})
+					sets = append(sets, cc.spans)
+				}
+			}
+		}
+		add(a, p.a)
+		fromA := len(moves)
+		if p.b != nil {
+			add(b, p.b)
+		}
+		for _, class := range partition(sets) {
+			var next pair
+			c := &charClass{spans: class.spans}
+			classMoves := make([]move, len(class.members))
+			for i, m := range class.members {
+				classMoves[i] = moves[m]
+				c.sources = append(c.sources, moves[m].c.(*charClass).sources...)
+				if m < fromA {
+					next.a = moves[m].target
+				} else {
+					next.b = moves[m].target
+				}
+			}
+			if next.a == nil || intersect && next.b == nil {
+				continue
+			}
+			c.setGroups(unionGroups(classMoves))
+			d.addTransitions(states[p], map[char]state{c: target(next)})
+		}
+	}
+	if limit > 0 && len(states) > limit {
+		return nil
+	}
+	return d
+}
+
+// embedDfa returns a copy of the DFA, with new states, as an NFA with a single final
+// state reached by empty transitions from the final states of the DFA.
+func embedDfa(d *automata) *automata {
+	a := &automata{Trans: make(transitions), final: []state{newState()}}
+	copies := map[state]state{}
+	copyOf := func(s state) state {
+		c, ok := copies[s]
+		if !ok {
+			c = newState()
+			copies[s] = c
+		}
+		return c
+	}
+	a.start = copyOf(d.start)
+	for _, s := range d.states() {
+		for c, t := range d.Trans[s] {
+			cc := c.(*charClass)
+			copied := &charClass{spans: cc.spans, sources: cc.sources}
+			copied.setGroups(list.New())
+			a.addTransitions(copyOf(s), map[char]state{copied: copyOf(t)})
+		}
+	}
+	for _, f := range d.final {
+		a.addTransitions(copyOf(f), map[char]state{&empty{}: a.final[0]})
+	}
+	return a
+}
+
+// emitDfa appends the instructions following the paths of the DFA, continuing at next
+// from its final states, and returns the first one. Each state tries its transitions,
+// in the order of their characters, before leaving the DFA if it is final.
+func emitDfa(d *automata, p *program, next int) int {
+	states := d.states()
+	entries := map[state]int{}
+	for _, s := range states {
+		entries[s] = p.split(-1, -1)
+	}
+	final := set[state]{}
+	for _, f := range d.final {
+		final[f] = true
+	}
+	for _, s := range states {
+		var alternatives []int
+		trans := d.Trans[s]
+		for _, c := range sortedChars(trans) {
+			alternatives = append(alternatives, p.add(inst{op: opChar, chars: c.matchSet(), out: entries[trans[c]]}))
+		}
+		if final[s] {
+			alternatives = append(alternatives, next)
+		}
+		if len(alternatives) == 0 {
+			// a state without transitions which is not final fails
+			alternatives = append(alternatives, p.add(inst{op: opChar}))
+		}
+		rest := alternatives[len(alternatives)-1]
+		for i := len(alternatives) - 2; i > 0; i-- {
+			rest = p.split(alternatives[i], rest)
+		}
+		p.insts[entries[s]].out, p.insts[entries[s]].out1 = alternatives[0], rest
+	}
+	return entries[d.start]
+}
+
+// Equivalent returns true if the regular expressions match exactly the same strings.
+// a.Difference(b) and b.Difference(a) give, with Witness, a string matched by only one
+// of them when they are not.
+func Equivalent(a, b *CompiledRegex) bool {
+	return Subset(a, b) && Subset(b, a)
+}
+
+// Subset returns true if every string matched by a is matched by b.
+func Subset(a, b *CompiledRegex) bool {
+	_, found := witness(a.table, b.table)
+	return !found
+}
+
+// IsEmpty returns true if the regular expression matches no string, not even the
+// empty one, e.g., a&b.
+func (r *CompiledRegex) IsEmpty() bool {
+	_, found := witness(r.table, nil)
+	return !found
+}
+
+// Witness returns the shortest string matched by the regular expression, preferring
+// printable ASCII characters, and true, or false if it matches nothing. Applied to
+// the difference of two regular expressions, it is a counterexample to their
+// equivalence.
+func (r *CompiledRegex) Witness() (string, bool) {
+	return witness(r.table, nil)
+}
+
+// witness returns the shortest string matched by the table a and not by the table b,
+// if it is not nil, by a breadth-first search of the pairs of their states, and false
+// if there is none. The characters of the string are the first printable ASCII
+// characters, if any, of the classes of characters of both tables followed.
+func witness(a, b *table) (string, bool) {
+	type pair struct{ a, b int32 }
+	classes := a.classSpans()
+	fromA := len(classes)
+	if b != nil {
+		classes = append(classes, b.classSpans()...)
+	}
+	type step struct {
+		c    rune
+		a, b int32
+	}
+	var steps []step
+	for _, class := range partition(classes) {
+		s := step{exampleChar(class.spans), -1, -1}
+		for _, m := range class.members {
+			if m < fromA {
+				s.a = int32(m)
+			} else {
+				s.b = int32(m - fromA)
+			}
+		}
+		if s.a != -1 {
+			steps = append(steps, s)
+		}
+	}
+
+	accepts := func(p pair) bool {
+		return a.final[p.a] && (p.b == -1 || !b.final[p.b])
+	}
+	start := pair{a.starts[textStart], -1}
+	if b != nil {
+		start.b = b.starts[textStart]
+	}
+	// the pair each pair was reached from, and on which character
+	type origin struct {
+		from pair
+		c    rune
+	}
+	reached := map[pair]origin{start: {}}
+	pending := []pair{start}
+	for len(pending) > 0 {
+		p := pending[0]
+		pending = pending[1:]
+		if accepts(p) {
+			var text []rune
+			for ; p != start; p = reached[p].from {
+				text = append(text, reached[p].c)
+			}
+			slices.Reverse(text)
+			return string(text), true
+		}
+		for _, s := range steps {
+			next := pair{a.next[int(p.a)*a.classes+int(s.a)], -1}
+			if next.a == -1 {
+				continue
+			}
+			if p.b != -1 && s.b != -1 {
+				next.b = b.next[int(p.b)*b.classes+int(s.b)]
+			}
+			if _, ok := reached[next]; !ok {
+				reached[next] = origin{p, s.c}
+				pending = append(pending, next)
+			}
+		}
+	}
+	return "", false
+}
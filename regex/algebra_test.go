@@ -0,0 +1,295 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIntersectionAndComplement(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{"[a-z]+&~(if|else)", []string{"x", "iff", "els", "elsewhere"}, []string{"", "if", "else", "X"}},
+		{"~a", []string{"", "b", "aa", "ab", "é"}, []string{"a"}},
+		{"~(a*)", []string{"b", "ab", "ba"}, []string{"", "a", "aaa"}},
+		{"~a*", []string{"", "b", "aa", "ab", "aba"}, []string{"a"}},
+		{"~0[0-9]", []string{"0", "1", "001"}, []string{"01", ""}},
+		{"[0-9]+&~(0[0-9]+)", []string{"0", "10", "907"}, []string{"", "01", "007", "a"}},
+		{"(.*a.*)&(.*b.*)&~(.*c.*)", []string{"ab", "ba", "xaybz"}, []string{"a", "b", "abc"}},
+		{"x(a+&aaa*)y|z", []string{"xaay", "xaaaay", "z"}, []string{"xay", "xy"}},
+		{"\\&|\\~", []string{"&", "~"}, []string{"", "\\"}},
+		{"[&~]", []string{"&", "~"}, []string{"a"}},
+		{"(ab&cd)?e", []string{"e"}, []string{"abe", "cde"}},
+		{"~~(ab)", []string{"ab"}, []string{"", "a", "abab"}},
+		{"(?i)~(if)", []string{"x", "iff"}, []string{"if", "IF", "iF"}},
+	}
+	for _, test := range tests {
+		r, err := Compile(test.pattern)
+		if err != nil {
+			t.Errorf("%q: %v", test.pattern, err)
+			continue
+		}
+		for _, s := range test.match {
+			if !r.Match(s) {
+				t.Errorf("%q should match %q", test.pattern, s)
+			}
+		}
+		for _, s := range test.noMatch {
+			if r.Match(s) {
+				t.Errorf("%q should not match %q", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestAlgebraMethods(t *testing.T) {
+	id := MustCompile("[a-z]+")
+	keyword := MustCompile("if|else")
+	digits := MustCompile("[0-9]+")
+
+	and, err := id.Intersect(MustCompile("[a-c]*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	or, err := id.Union(digits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	not, err := keyword.Complement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	minus, err := id.Difference(keyword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		r       *CompiledRegex
+		match   []string
+		noMatch []string
+	}{
+		{and, []string{"abc", "cab"}, []string{"", "abd"}},
+		{or, []string{"abc", "123"}, []string{"", "a1"}},
+		{not, []string{"", "iff", "1"}, []string{"if", "else"}},
+		{minus, []string{"iff", "x"}, []string{"if", "else", "", "1"}},
+	}
+	for _, test := range tests {
+		for _, s := range test.match {
+			if !test.r.Match(s) {
+				t.Errorf("%q should match %q", test.r.source, s)
+			}
+		}
+		for _, s := range test.noMatch {
+			if test.r.Match(s) {
+				t.Errorf("%q should not match %q", test.r.source, s)
+			}
+		}
+	}
+
+	// capture groups of both operands of a union are kept
+	groups, err := MustCompile("(a)b").Union(MustCompile("(c)d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := groups.Submatches("cd"); loc == nil || loc[1] != nil || loc[2] == nil || loc[2].Text != "c" {
+		t.Errorf("expected group 2 to match c in cd, got %v", loc)
+	}
+
+	// operands with groups of the same name
+	x1, x2 := MustCompile("(?<x>a+)b"), MustCompile("(?<x>a)b+")
+	both, err := x1.Intersect(x2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !both.Match("ab") || both.Match("aab") || both.Match("abb") {
+		t.Errorf("%q matches ab only", both.source)
+	}
+	either, err := x1.Union(x2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s, x := range map[string]string{"aab": "aa", "abb": "a"} {
+		if named := either.NamedSubmatches(s); named == nil || named["x"] == nil || named["x"].Text != x {
+			t.Errorf("expected x to capture %q in %q, got %v", x, s, named)
+		}
+	}
+	if either.SubexpIndex("x") != 1 || len(either.SubexpNames()) != 3 {
+		t.Errorf("unexpected groups %v", either.SubexpNames())
+	}
+
+	// lenient operands are not parsed again
+	lenient, err := NewRegex("ab(cd").Union(MustCompile("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lenient.Match("abcd") || !lenient.Match("x") {
+		t.Errorf("%q should match abcd and x", lenient.source)
+	}
+
+	// the result is compiled with the configuration of the receiver
+	small := Config{Minimise: true, MaxStates: 100}.NewRegex("a{20}")
+	var size *SizeError
+	if _, err := small.Union(MustCompile("b{40}")); !errors.As(err, &size) || size.Limit != 100 {
+		t.Errorf("expected a size error over the budget of the receiver, got %v", err)
+	}
+	if _, err := MustCompile("b{40}").Union(small); err != nil {
+		t.Errorf("union failed with the default configuration of the receiver: %v", err)
+	}
+}
+
+func TestAlgebraFindAndSubmatches(t *testing.T) {
+	r := MustCompile("(?<id>[a-z]+&~(if|else))|(?<kw>if|else)")
+	if got := r.FindAll("if x iff else", -1); len(got) != 4 || got[0] != "if" || got[2] != "iff" {
+		t.Errorf("unexpected matches %q", got)
+	}
+	named := r.NamedSubmatches("iff")
+	if named == nil || named["id"] == nil || named["id"].Text != "iff" || named["kw"] != nil {
+		t.Errorf("expected id to capture iff, got %v", named)
+	}
+	named = r.NamedSubmatches("if")
+	if named == nil || named["kw"] == nil || named["id"] != nil {
+		t.Errorf("expected kw to capture if, got %v", named)
+	}
+
+	s := MustCompileSet("if|else", "[a-z]+&~(if|else)")
+	if m := s.Match("if"); len(m) != 1 || m[0] != 0 {
+		t.Errorf("expected if to match the keyword only, got %v", m)
+	}
+	if m := s.Match("iff"); len(m) != 1 || m[0] != 1 {
+		t.Errorf("expected iff to match the identifier only, got %v", m)
+	}
+}
+
+func TestAlgebraErrors(t *testing.T) {
+	for _, p := range []string{"~", "a|~", "(~)", "a&\\bb", "~^a", "~(a$)&b"} {
+		_, err := Compile(p)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("%q: expected a syntax error, got %v", p, err)
+		}
+	}
+
+	_, err := MustCompile("a+").Difference(MustCompile("\\bab"))
+	var syntax *SyntaxError
+	if !errors.As(err, &syntax) || syntax.Offset != 11 || syntax.Construct != "\\bab" {
+		t.Errorf("expected a syntax error at the second operand, got %v", err)
+	}
+
+	c := Config{Minimise: true, MaxStates: 1000}
+	_, err = c.Compile("[ab]*a[ab]{20}&[ab]*")
+	var size *SizeError
+	if !errors.As(err, &size) {
+		t.Errorf("expected a size error, got %v", err)
+	}
+}
+
+func TestAlgebraEncoding(t *testing.T) {
+	r := MustCompile("[a-z]+&~(if|else)")
+	data, err := r.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded CompiledRegex
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"if", "iff", "else", "x", ""} {
+		if loaded.Match(s) != r.Match(s) {
+			t.Errorf("%q: loaded regex matches %v", s, loaded.Match(s))
+		}
+	}
+}
+
+func TestEscapeAlgebra(t *testing.T) {
+	if e := Escape("a&b~"); e != "a\\&b\\~" {
+		t.Errorf("expected a\\&b\\~, got %q", e)
+	}
+	if !MustCompile(Escape("&&~")).Match("&&~") {
+		t.Error("escaped pattern should match itself")
+	}
+}
+
+func TestAlgebraGenerate(t *testing.T) {
+	for _, p := range []string{"[a-z]+&~(if|else)", "~(a*)", "x(a+&aaa*)y"} {
+		r := MustCompile(p)
+		for range 100 {
+			if s := r.Generate(); !r.Match(s) {
+				t.Errorf("%q generated %q which it does not match", p, s)
+			}
+		}
+	}
+}
+
+func TestEquivalentAndSubset(t *testing.T) {
+	tests := []struct {
+		a, b       string
+		subset     bool
+		equivalent bool
+	}{
+		{"a+", "aa*", true, true},
+		{"(a|b)*", "(a*b*)*", true, true},
+		{"[0-9]+", "\\d+", true, true},
+		{"if", "[a-z]+", true, false},
+		{"[a-z]+", "if", false, false},
+		{"[a-z]+&~(if|else)", "[a-z]+", true, false},
+		{"", "~(.+)", true, true},
+		{"x?", "~(.+)", false, false},
+		{"\\bif\\b", "if", true, true},
+		{"a$", "a", true, true},
+		{"(?i)if", "[iI][fF]", true, true},
+	}
+	for _, test := range tests {
+		a, b := MustCompile(test.a), MustCompile(test.b)
+		if s := Subset(a, b); s != test.subset {
+			t.Errorf("Subset(%q, %q): expected %v, got %v", test.a, test.b, test.subset, s)
+		}
+		if e := Equivalent(a, b); e != test.equivalent {
+			t.Errorf("Equivalent(%q, %q): expected %v, got %v", test.a, test.b, test.equivalent, e)
+		}
+	}
+}
+
+func TestEmptyAndWitness(t *testing.T) {
+	tests := []struct {
+		pattern string
+		witness string
+		empty   bool
+	}{
+		{"a&b", "", true},
+		{"[a-z]+&[0-9]+", "", true},
+		{"~(.*)", "", true},
+		{"", "", false},
+		{"a*", "", false},
+		{"[a-z]+&~(if|else)", "a", false},
+		{"x(ab)+y|xaaaz", "xaby", false},
+		{"[^\\x00-\\x1f]+&~[a-z]+", " ", false},
+		{"\\bab", "ab", false},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.pattern)
+		if e := r.IsEmpty(); e != test.empty {
+			t.Errorf("%q: expected IsEmpty %v, got %v", test.pattern, test.empty, e)
+		}
+		w, ok := r.Witness()
+		if ok == test.empty || w != test.witness {
+			t.Errorf("%q: expected witness %q, got %q, %v", test.pattern, test.witness, w, ok)
+		}
+		if ok && !r.Match(w) {
+			t.Errorf("%q: does not match its witness %q", test.pattern, w)
+		}
+	}
+
+	// a counterexample to the equivalence of a refactored pattern
+	before, after := MustCompile("[a-z][a-z0-9]*"), MustCompile("[a-z]+[0-9]*")
+	d, err := before.Difference(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, ok := d.Witness(); !ok || w != "a0a" {
+		t.Errorf("expected counterexample a0a, got %q, %v", w, ok)
+	}
+}
@@ -0,0 +1,157 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+type (
+	// SetAnalysis describes how the regular expressions of a set compete for the texts
+	// they match, as found in the states of the DFA of the set. Regular expressions are
+	// identified by their index in the set.
+	SetAnalysis struct {
+		// Shadowed are, for each regular expression which is never the highest priority
+		// match of a text, the regular expressions having priority over it on the texts
+		// it matches.
+		Shadowed map[int][]int
+
+		// Unmatched are the regular expressions matching nothing, such as a&b, in order.
+		// They are not shadowed.
+		Unmatched []int
+
+		// Overlaps are the pairs of regular expressions matching some same texts, with
+		// the shortest of them, in the order of the regular expressions.
+		Overlaps []SetOverlap
+
+		// Empty are the regular expressions matching the empty string, in order.
+		Empty []int
+	}
+
+	// SetOverlap is a pair of regular expressions of a set, First having priority over
+	// Second, which both match Example.
+	SetOverlap struct {
+		First, Second int
+		Example       string
+	}
+)
+
+// Analyze returns the regular expressions of the set which are never the highest
+// priority match of a text, those matching nothing, the pairs of regular expressions matching the same texts
+// and those matching the empty string. Assertions are taken into account, the texts
+// being matched at any position and followed by any character.
+func (s *RegexSet) Analyze() *SetAnalysis {
+	t := s.table
+	examples := t.examples()
+	analysis := &SetAnalysis{Shadowed: map[int][]int{}}
+
+	wins, matched := make([]bool, len(s.Regexes)), make([]bool, len(s.Regexes))
+	shadows := make([]set[int], len(s.Regexes))
+	overlaps := map[[2]int]string{}
+	for i := range s.Regexes {
+		shadows[i] = set[int]{}
+	}
+	empty := set[int]{}
+	for state, example := range examples {
+		m := t.matches[state]
+		if example == nil || m == nil {
+			continue
+		}
+		for _, full := range m.full {
+			if len(full) == 0 {
+				continue
+			}
+			wins[full[0]] = true
+			for k, i := range full {
+				matched[i] = true
+				if *example == "" {
+					empty[i] = true
+				}
+				if k > 0 {
+					shadows[i][full[0]] = true
+				}
+				for _, j := range full[k+1:] {
+					if e, ok := overlaps[[2]int{i, j}]; !ok || shorter(*example, e) {
+						overlaps[[2]int{i, j}] = *example
+					}
+				}
+			}
+		}
+	}
+	for i := range s.Regexes {
+		if !matched[i] {
+			analysis.Unmatched = append(analysis.Unmatched, i)
+		} else if !wins[i] {
+			analysis.Shadowed[i] = slices.Sorted(maps.Keys(shadows[i]))
+		}
+		if empty[i] {
+			analysis.Empty = append(analysis.Empty, i)
+		}
+	}
+	for pair, example := range overlaps {
+		analysis.Overlaps = append(analysis.Overlaps, SetOverlap{pair[0], pair[1], example})
+	}
+	slices.SortFunc(analysis.Overlaps, func(a, b SetOverlap) int {
+		if a.First != b.First {
+			return a.First - b.First
+		}
+		return a.Second - b.Second
+	})
+	return analysis
+}
+
+// examples returns, for each state of the table, the shortest text leading to it from
+// a start state, by breadth-first search, or nil if the state is not reachable. The
+// characters of the texts are the first printable ASCII characters, if any, of the
+// classes of characters followed, trying the classes in order.
+func (t *table) examples() []*string {
+	// the classes in the order of their first character, with their example character
+	type class struct {
+		index int
+		char  rune
+		first rune
+	}
+	var classes []class
+	for i, spans := range t.classSpans() {
+		classes = append(classes, class{i, exampleChar(spans), spans[0].from})
+	}
+	slices.SortFunc(classes, func(a, b class) int {
+		return cmp.Compare(a.first, b.first)
+	})
+	texts := make([]*string, len(t.states))
+	var pending []int32
+	for _, s := range t.starts {
+		if texts[s] == nil {
+			texts[s] = new(string)
+			pending = append(pending, s)
+		}
+	}
+	for len(pending) > 0 {
+		s := pending[0]
+		pending = pending[1:]
+		for _, c := range classes {
+			if next := t.next[int(s)*t.classes+c.index]; next != -1 && texts[next] == nil {
+				text := *texts[s] + string(c.char)
+				texts[next] = &text
+				pending = append(pending, next)
+			}
+		}
+	}
+	return texts
+}
+
+// shorter returns true if a is shorter than b, or as long and before it.
+func shorter(a, b string) bool {
+	return len(a) < len(b) || len(a) == len(b) && a < b
+}
+
+// exampleChar returns the first printable ASCII character of the spans, or their
+// first character if they have none.
+func exampleChar(spans spanSet) rune {
+	if printable := spans.intersect(asciiPrintable); len(printable) > 0 {
+		return printable[0].from
+	}
+	return spans[0].from
+}
@@ -0,0 +1,150 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import "container/list"
+
+// Assertions (^, $, \A, \z, \b, \B) match the empty string at positions satisfying a
+// condition on the characters around them. They label NFA transitions which are
+// followed like empty transitions when their condition holds. The DFA resolves them
+// with look-behind context bits on its states, recording whether a state is at the
+// start of the text or after a word character, and by looking ahead at the class of
+// the character consumed next (or the end of the text) when following a transition
+// or deciding whether a state is final.
+
+type (
+	assertKind uint8
+
+	// assertion is a zero-width assertion on the position in the input.
+	assertion struct {
+		kind    assertKind
+		pattern string
+	}
+
+	// context is what is known of the input before a position for evaluating assertions.
+	context uint8
+
+	// lookahead is what is known of the input after a position for evaluating assertions.
+	lookahead uint8
+)
+
+const (
+	// beginText holds at the start of the text (\A, and ^ as there is no multiline mode).
+	beginText assertKind = iota
+
+	// endText holds at the end of the text (\z, and $ as there is no multiline mode).
+	endText
+
+	// wordBoundary holds between a word and a non-word character (\b), with the start
+	// and end of the text counting as non-word characters.
+	wordBoundary
+
+	// notWordBoundary holds where wordBoundary does not (\B).
+	notWordBoundary
+)
+
+const (
+	// textStart is the context at the start of the text.
+	textStart context = iota
+
+	// afterOther is the context after a non-word character.
+	afterOther
+
+	// afterWord is the context after a word character.
+	afterWord
+
+	contexts = 3
+)
+
+const (
+	// beforeEnd is the lookahead at the end of the text.
+	beforeEnd lookahead = iota
+
+	// beforeOther is the lookahead before a non-word character.
+	beforeOther
+
+	// beforeWord is the lookahead before a word character.
+	beforeWord
+)
+
+// wordChars are the ASCII word characters used by \b and \B, as in \w.
+var wordChars = spanSet{{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}}
+
+func isWordChar(c rune) bool {
+	return c >= 0 && c < 128 && wordChars.search(c)
+}
+
+// contextAfter returns the context after the character, or at the start of the text
+// if the character is negative.
+func contextAfter(c rune) context {
+	if c < 0 {
+		return textStart
+	} else if isWordChar(c) {
+		return afterWord
+	}
+	return afterOther
+}
+
+// lookaheadBefore returns the lookahead before the character, or at the end of the
+// text if the character is negative.
+func lookaheadBefore(c rune) lookahead {
+	if c < 0 {
+		return beforeEnd
+	} else if isWordChar(c) {
+		return beforeWord
+	}
+	return beforeOther
+}
+
+// holds returns true if an assertion of this kind holds at a position with the context
+// and lookahead.
+func (k assertKind) holds(ctx context, next lookahead) bool {
+	switch k {
+	case beginText:
+		return ctx == textStart
+	case endText:
+		return next == beforeEnd
+	case wordBoundary:
+		return (ctx == afterWord) != (next == beforeWord)
+	default:
+		return (ctx == afterWord) == (next == beforeWord)
+	}
+}
+
+func (a *assertion) Pattern() string {
+	return a.pattern
+}
+
+func (a *assertion) isEmpty() bool {
+	return false
+}
+
+func (a *assertion) groups() *list.List {
+	return nil
+}
+
+func (a *assertion) setGroups(*list.List) {}
+
+func (a *assertion) nfa() *automata {
+	return charNfa(a)
+}
+
+func (a *assertion) emit(p *program, next int) int {
+	return p.add(inst{op: opAssert, assert: a.kind, out: next})
+}
+
+func (a *assertion) match(rune) bool {
+	return false
+}
+
+func (a *assertion) spanSet() spanSet {
+	return nil
+}
+
+func (a *assertion) matchSet() spanSet {
+	return nil
+}
+
+func (a *assertion) random() string {
+	return ""
+}
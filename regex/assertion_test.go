@@ -0,0 +1,112 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestAnchors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"^abc$", []string{"abc"}, []string{"abcd", ""}},
+		{"\\Aa*\\z", []string{"", "aaa"}, []string{"ab"}},
+		{"a$b", nil, []string{"ab", "a$b"}},
+		{"a^b", nil, []string{"ab"}},
+		{"\\bfoo\\b", []string{"foo"}, []string{"foox"}},
+		{"foo\\b.*", []string{"foo", "foo bar"}, []string{"foobar"}},
+		{"foo\\B.*", []string{"foobar"}, []string{"foo", "foo bar"}},
+		{"a\\b\\Bb", nil, []string{"ab"}},
+		{"(^a|b)+", []string{"a", "ab", "bbb"}, []string{"aa", "ba"}},
+		{"x\\$", []string{"x$"}, []string{"x"}},
+	}
+	for _, test := range tests {
+		for _, c := range []Config{{Minimise: true}, {Minimise: false}} {
+			r, err := c.Compile(test.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range test.matches {
+				if !r.Match(s) || !r.Matcher().Match(s) {
+					t.Errorf("%q did not match %q (%v)", test.pattern, s, c)
+				}
+			}
+			for _, s := range test.fails {
+				if r.Match(s) || r.Matcher().Match(s) {
+					t.Errorf("%q matched %q (%v)", test.pattern, s, c)
+				}
+			}
+		}
+	}
+}
+
+func TestFindAssertions(t *testing.T) {
+	texts := []string{
+		"concat cat catalog cat",
+		"a ab abc b_c 12 é x",
+		"",
+		"aaa",
+		"if iffy if(x) elif",
+	}
+	for _, pattern := range []string{
+		"\\bcat\\b", "\\b\\w", "\\w\\b", "^a", "a$", "\\Ba+", "a*\\b", "\\bif\\b", "\\B", "^|$", "(c|\\b)a",
+	} {
+		expected := regexp.MustCompile(pattern)
+		expected.Longest()
+		r := MustCompile(pattern)
+		for _, text := range texts {
+			var want, got [][2]int
+			for _, loc := range expected.FindAllStringIndex(text, -1) {
+				want = append(want, [2]int{loc[0], loc[1]})
+			}
+			for _, loc := range r.FindAllIndex(text, -1) {
+				got = append(got, [2]int{loc.Start, loc.End})
+			}
+			if !slices.Equal(want, got) {
+				t.Errorf("%q in %q: expected %v, found %v", pattern, text, want, got)
+			}
+		}
+	}
+}
+
+func TestSubmatchesAssertions(t *testing.T) {
+	r := MustCompile("\\b(\\w+)\\b(\\W*)$")
+	groups := r.FindSubmatches("one, two!")
+	expected := [][2]int{{5, 9}, {5, 8}, {8, 9}}
+	if !equalSpans(spans(groups), expected) {
+		t.Error("expected", expected, "got", spans(groups))
+	}
+}
+
+func TestMatcherFullMatchBefore(t *testing.T) {
+	m := MustCompile("if\\b").Matcher()
+	m.MatchNext('i')
+	m.MatchNext('f')
+	if m.LastMatch != FullMatch {
+		t.Error("'if\\b' is not a full match of 'if'")
+	}
+	if !m.FullMatchBefore(' ') || !m.FullMatchBefore('(') {
+		t.Error("'if\\b' is not a full match of 'if' before a non-word character")
+	}
+	if m.FullMatchBefore('f') {
+		t.Error("'if\\b' is a full match of 'if' before 'f'")
+	}
+	if m.MatchNext('f') != NoMatch {
+		t.Error("'if\\b' matched 'iff'")
+	}
+
+	m = MustCompile("\\bx").Matcher()
+	m.ResetAfter('a')
+	if m.MatchNext('x') != NoMatch {
+		t.Error("'\\bx' matched 'x' after 'a'")
+	}
+	m.ResetAfter(' ')
+	if m.MatchNext('x') != FullMatch {
+		t.Error("'\\bx' did not match 'x' after ' '")
+	}
+}
@@ -4,14 +4,17 @@ package regex
 
 import (
 	"container/list"
+	"encoding/binary"
 	"maps"
-	"reflect"
 	"slices"
 	"strconv"
+	"sync/atomic"
 )
 
 type (
-	stateObj struct{ _ uint8 }
+	// stateObj is a state of an automaton. States are compared by identity; the id
+	// is unique in the process and only used to build canonical keys for sets of states.
+	stateObj struct{ id uint64 }
 
 	state *stateObj
 
@@ -23,93 +26,355 @@ type (
 		Trans transitions
 		start state
 		final []state
+
+		// starts are the start states of a DFA by the context of the position where
+		// matching starts, starts[textStart] being start. They differ only for regular
+		// expressions with assertions.
+		starts []state
+
+		// accepts are, for DFAs of regular expressions with assertions on the next
+		// character, the bit sets of the lookaheads before which each state is final;
+		// final are then the states which are final at the end of the text. It is nil
+		// when finality does not depend on the next character.
+		accepts map[state]uint8
+
+		// pattern is, for the NFA of a set of regular expressions, the index of the
+		// regular expression to which each state belongs, final[i] being the final
+		// state of regular expression i. It is nil for other automata.
+		pattern map[state]int
+
+		// matches are, for the DFA of a set of regular expressions, the regular
+		// expressions partially and fully matched in each state.
+		matches map[state]*setMatch
 	}
 )
 
+// lastStateId is the id of the last state created, for assigning unique state ids.
+var lastStateId atomic.Uint64
+
+func newState() state {
+	return &stateObj{lastStateId.Add(1)}
+}
+
+// dfa converts the NFA to a DFA by subset construction. The characters on the
+// transitions out of the NFA states making up a DFA state are partitioned into
+// disjoint character classes, so that every character has at most one transition
+// out of a DFA state. When the NFA has assertions, DFA states are made of a set of
+// NFA states and the context of their position; assertions are resolved when leaving
+// the state, depending on whether the next character is a word character.
 func (auto *automata) dfa() *automata {
+	return auto.dfaWithin(0)
+}
+
+// dfaWithin is dfa abandoning the subset construction, and returning nil, if the DFA
+// has more than limit states. There is no limit if it is 0.
+func (auto *automata) dfaWithin(limit int) *automata {
 	dfa := automata{
 		Trans: make(transitions),
 		start: nil,
 		final: []state{},
 	}
 
-	dfaStates := map[state]set[state]{}
-	explored := make(chan set[state], 1000)
+	kinds := auto.assertions()
+	trackStart := kinds[beginText]
+	trackWord := kinds[wordBoundary] || kinds[notWordBoundary]
+	if kinds[endText] || trackWord {
+		dfa.accepts = map[state]uint8{}
+	}
+	if auto.pattern != nil {
+		dfa.matches = map[state]*setMatch{}
+	}
+	lookaheads := []lookahead{beforeOther}
+	if trackWord {
+		lookaheads = append(lookaheads, beforeWord)
+	}
+	resolve := func(states set[state], ctx context, next lookahead) set[state] {
+		if len(kinds) == 0 {
+			return states
+		}
+		return auto.resolve(states, ctx, next)
+	}
+
+	// DFA states indexed by the canonical key of their set of NFA states and context
+	dfaStates := map[string]state{}
+	type pending struct {
+		source state
+		states set[state]
+		ctx    context
+	}
+	var explored []pending
+
+	dfaState := func(states set[state], ctx context) state {
+		// contexts which are not distinguished by any assertion are merged
+		if ctx == textStart && !trackStart {
+			ctx = afterOther
+		}
+		if ctx == afterWord && !trackWord {
+			ctx = afterOther
+		}
+		k := key(states)
+		if len(kinds) > 0 {
+			k += string(rune(ctx))
+		}
+		target, ok := dfaStates[k]
+		if !ok {
+			target = newState()
+			dfaStates[k] = target
+			explored = append(explored, pending{target, states, ctx})
+			if auto.containsFinal(resolve(states, ctx, beforeEnd)) {
+				dfa.final = append(dfa.final, target)
+			}
+			if dfa.accepts != nil {
+				var accepts uint8
+				for _, next := range []lookahead{beforeEnd, beforeOther, beforeWord} {
+					if auto.containsFinal(auto.resolve(states, ctx, next)) {
+						accepts |= 1 << next
+					}
+				}
+				dfa.accepts[target] = accepts
+			}
+			if dfa.matches != nil {
+				dfa.matches[target] = auto.setMatch(states, func(next lookahead) set[state] {
+					return resolve(states, ctx, next)
+				})
+			}
+		}
+		return target
+	}
+
 	reachable := &set[state]{}
 	eClosure(auto.start, auto.Trans, reachable)
-	explored <- *reachable
-
-	dfa.start = &stateObj{}
-	dfaStates[dfa.start] = *reachable
-	if auto.containsFinal(reachable) {
-		dfa.final = append(dfa.final, dfa.start)
+	for ctx := range context(contexts) {
+		dfa.starts = append(dfa.starts, dfaState(*reachable, ctx))
 	}
+	dfa.start = dfa.starts[textStart]
+
+	// the characters matched by each NFA char, computed once
+	matchSets := map[char]spanSet{}
 
 	for len(explored) > 0 {
-		dfaState := <-explored
-		source := find(dfaStates, dfaState)
-
-		// union all outgoing character transitions on any State of the DFA State
-		chars := map[string][]char{}
-		for s := range dfaState {
-			trans := auto.Trans[s]
-			for c := range trans {
-				if !c.isEmpty() {
-					pattern := c.Pattern()
-					chars[pattern] = append(chars[pattern], c)
+		if limit > 0 && len(dfaStates) > limit {
+			return nil
+		}
+		source, nfaStates, ctx := explored[0].source, explored[0].states, explored[0].ctx
+		explored = explored[1:]
+
+		// union all outgoing character transitions on any State of the DFA State,
+		// keeping their targets so that they need not be looked up again. Characters
+		// which do not match anything (lists) are only used for random generation and
+		// are grouped by pattern instead of being partitioned. When word boundaries
+		// are tracked, the states left on word and non-word characters can differ and
+		// their characters are restricted accordingly.
+		var moves []move
+		var sets []spanSet
+		generators := map[string][]move{}
+		for _, next := range lookaheads {
+			for s := range resolve(nfaStates, ctx, next) {
+				trans := auto.Trans[s]
+				for c, t := range trans {
+					if _, ok := c.(*assertion); ok || c.isEmpty() {
+						continue
+					}
+					m, ok := matchSets[c]
+					if !ok {
+						m = c.matchSet()
+						matchSets[c] = m
+					}
+					if len(m) == 0 {
+						if next == beforeOther {
+							generators[c.Pattern()] = append(generators[c.Pattern()], move{c, t})
+						}
+						continue
+					}
+					if trackWord {
+						if next == beforeWord {
+							m = m.intersect(wordChars)
+						} else {
+							m = m.minus(wordChars)
+						}
+						if len(m) == 0 {
+							continue
+						}
+					}
+					moves = append(moves, move{c, t})
+					sets = append(sets, m)
 				}
 			}
 		}
 
-		// find reachable set of states for each outgoing character
-		for _, cs := range chars {
-			reachable = &set[state]{}
-			groups := set[int]{}
-			var combinedChar char = nil
-			for _, c := range cs {
-				if combinedChar == nil {
-					combinedChar = c
-				}
-				for i := c.groups().Front(); i != nil; i = i.Next() {
-					groups[i.Value.(int)] = true
+		addTransition := func(c char, moves []move, ctx context) {
+			reachable := &set[state]{}
+			for _, m := range moves {
+				if !(*reachable)[m.target] {
+					eClosure(m.target, auto.Trans, reachable)
 				}
-				for s := range dfaState {
-					trans := auto.Trans[s]
-					if t, ok := trans[c]; ok {
-						eClosure(t, auto.Trans, reachable)
-					}
+			}
+			dfa.addTransitions(source, map[char]state{c: dfaState(*reachable, ctx)})
+		}
+
+		// a transition for each class of characters, to the set of states reachable
+		// on all the NFA transitions matching the characters of the class
+		for _, class := range partition(sets) {
+			classMoves := make([]move, len(class.members))
+			c := &charClass{spans: class.spans}
+			for i, m := range class.members {
+				classMoves[i] = moves[m]
+				if slices.Index(c.sources, moves[m].c) == -1 {
+					c.sources = append(c.sources, moves[m].c)
 				}
 			}
+			c.setGroups(unionGroups(classMoves))
+			addTransition(c, classMoves, contextAfter(class.spans[0].from))
+		}
 
-			union := slices.Sorted(maps.Keys(groups))
-			newGroups := list.New()
-			for _, g := range union {
-				newGroups.PushBack(g)
+		for _, genMoves := range generators {
+			combinedChar := genMoves[0].c
+			combinedChar.setGroups(unionGroups(genMoves))
+			addTransition(combinedChar, genMoves, afterOther)
+		}
+	}
+	if limit > 0 && len(dfaStates) > limit {
+		return nil
+	}
+	return &dfa
+}
+
+// assertions returns the kinds of assertions on the transitions of the automaton.
+func (auto *automata) assertions() map[assertKind]bool {
+	kinds := map[assertKind]bool{}
+	for _, trans := range auto.Trans {
+		for c := range trans {
+			if a, ok := c.(*assertion); ok {
+				kinds[a.kind] = true
 			}
-			combinedChar.setGroups(newGroups)
+		}
+	}
+	return kinds
+}
 
-			target := find(dfaStates, *reachable)
-			if target == nil {
-				target = &stateObj{}
-				dfaStates[target] = *reachable
-				explored <- *reachable
+// resolve returns the states reachable from the states through empty transitions and
+// transitions on assertions holding at a position with the context and lookahead.
+// The states themselves are returned when no assertion is reachable.
+func (auto *automata) resolve(states set[state], ctx context, next lookahead) set[state] {
+	var pending []state
+	for s := range states {
+		for c, t := range auto.Trans[s] {
+			if a, ok := c.(*assertion); ok && a.kind.holds(ctx, next) && !states[t] {
+				pending = append(pending, t)
 			}
-			if auto.containsFinal(reachable) && slices.Index(dfa.final, target) == -1 {
-				dfa.final = append(dfa.final, target)
+		}
+	}
+	if len(pending) == 0 {
+		return states
+	}
+	resolved := maps.Clone(states)
+	for len(pending) > 0 {
+		s := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if resolved[s] {
+			continue
+		}
+		resolved[s] = true
+		for c, t := range auto.Trans[s] {
+			if a, ok := c.(*assertion); (c.isEmpty() || ok && a.kind.holds(ctx, next)) && !resolved[t] {
+				pending = append(pending, t)
 			}
-			_, ok := dfa.Trans[source]
-			if !ok {
-				dfa.Trans[source] = map[char]state{}
+		}
+	}
+	return resolved
+}
+
+// move is a transition on a character to a target state.
+type move struct {
+	c      char
+	target state
+}
+
+// unionGroups returns the sorted union of the capture groups of the characters of
+// the moves.
+func unionGroups(moves []move) *list.List {
+	groups := set[int]{}
+	for _, m := range moves {
+		for i := m.c.groups().Front(); i != nil; i = i.Next() {
+			groups[i.Value.(int)] = true
+		}
+	}
+	union := list.New()
+	for _, g := range slices.Sorted(maps.Keys(groups)) {
+		union.PushBack(g)
+	}
+	return union
+}
+
+// class is a set of characters matched by the same members of a list of span sets.
+type class struct {
+	spans   spanSet
+	members []int
+}
+
+// partition splits the characters in the span sets into disjoint classes, where all
+// the characters in a class are matched by exactly the same span sets. The span sets
+// must be compact. Classes are returned in the order of their first character.
+func partition(sets []spanSet) []*class {
+	type boundary struct {
+		at   rune
+		set  int
+		open bool
+	}
+	var boundaries []boundary
+	for i, s := range sets {
+		for _, sp := range s {
+			boundaries = append(boundaries, boundary{sp.from, i, true}, boundary{sp.to + 1, i, false})
+		}
+	}
+	slices.SortFunc(boundaries, func(a, b boundary) int {
+		return int(a.at) - int(b.at)
+	})
+
+	var classes []*class
+	byMembers := map[string]*class{}
+	active := make([]bool, len(sets))
+	activeCount := 0
+	for i := 0; i < len(boundaries); {
+		at := boundaries[i].at
+		for ; i < len(boundaries) && boundaries[i].at == at; i++ {
+			if active[boundaries[i].set] != boundaries[i].open {
+				active[boundaries[i].set] = boundaries[i].open
+				if boundaries[i].open {
+					activeCount++
+				} else {
+					activeCount--
+				}
 			}
-			dfa.Trans[source][combinedChar] = target
 		}
+		if activeCount == 0 || i == len(boundaries) {
+			continue
+		}
+		var members []int
+		k := make([]byte, 0, activeCount*2)
+		for set, a := range active {
+			if a {
+				members = append(members, set)
+				k = binary.AppendUvarint(k, uint64(set))
+			}
+		}
+		c, ok := byMembers[string(k)]
+		if !ok {
+			c = &class{members: members}
+			byMembers[string(k)] = c
+			classes = append(classes, c)
+		}
+		c.spans = append(c.spans, span{at, boundaries[i].at - 1})
 	}
-	return &dfa
+	for _, c := range classes {
+		c.spans = c.spans.compact()
+	}
+	return classes
 }
 
-func (auto *automata) containsFinal(reachable *set[state]) bool {
-	for s := range *reachable {
-		if s == auto.final[0] {
+func (auto *automata) containsFinal(reachable set[state]) bool {
+	for _, f := range auto.final {
+		if reachable[f] {
 			return true
 		}
 	}
@@ -189,13 +454,19 @@ func eClosure(from state, trans transitions, closure *set[state]) {
 	}
 }
 
-func find(states map[state]set[state], state set[state]) state {
-	for k, v := range states {
-		if reflect.DeepEqual(v, state) {
-			return k
-		}
+// key returns a canonical key for a set of states, made of their sorted ids, which
+// is used to find the DFA state corresponding to a set of NFA states.
+func key(states set[state]) string {
+	ids := make([]uint64, 0, len(states))
+	for s := range states {
+		ids = append(ids, s.id)
+	}
+	slices.Sort(ids)
+	k := make([]byte, 0, len(ids)*binary.MaxVarintLen64)
+	for _, id := range ids {
+		k = binary.AppendUvarint(k, id)
 	}
-	return nil
+	return string(k)
 }
 
 func label(groups *list.List) string {
@@ -217,8 +488,8 @@ func label(groups *list.List) string {
 func charNfa(c char) *automata {
 	a := automata{
 		Trans: make(transitions),
-		start: &stateObj{},
-		final: []state{&stateObj{}},
+		start: newState(),
+		final: []state{newState()},
 	}
 	a.addTransitions(a.start, map[char]state{c: a.final[0]})
 	return &a
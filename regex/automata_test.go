@@ -0,0 +1,76 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	classes := partition([]spanSet{
+		{{'a', 'z'}},
+		{{'x', 'x'}},
+		{{'0', '9'}, {'a', 'c'}},
+	})
+	expected := []struct {
+		spans   spanSet
+		members []int
+	}{
+		{spanSet{{'0', '9'}}, []int{2}},
+		{spanSet{{'a', 'c'}}, []int{0, 2}},
+		{spanSet{{'d', 'w'}, {'y', 'z'}}, []int{0}},
+		{spanSet{{'x', 'x'}}, []int{0, 1}},
+	}
+	if len(classes) != len(expected) {
+		t.Fatal("expected", len(expected), "classes, got", len(classes))
+	}
+	for i, c := range classes {
+		if !slices.Equal(c.spans, expected[i].spans) || !slices.Equal(c.members, expected[i].members) {
+			t.Error("class", i, "expected", expected[i], "got", *c)
+		}
+	}
+}
+
+func TestDisjointTransitions(t *testing.T) {
+	for _, pattern := range []string{"[a-z]+|x1", "x1|[a-z]+", "(?i)k|[a-z]2", ".|a.b|\\w\\d"} {
+		r := NewRegex(pattern)
+		for _, s := range r.Dfa.states() {
+			var sets []spanSet
+			for c := range r.Dfa.Trans[s] {
+				sets = append(sets, c.matchSet())
+			}
+			for i := range sets {
+				for j := i + 1; j < len(sets); j++ {
+					if len(sets[i].intersect(sets[j])) > 0 {
+						t.Errorf("%q: overlapping transitions %v and %v", pattern, sets[i], sets[j])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOverlappingClasses(t *testing.T) {
+	// repeated as matching used to depend on the iteration order of transitions
+	for i := 0; i < 20; i++ {
+		r := NewRegex("[a-z]+|x1")
+		if !r.Match("x1") {
+			t.Fatal("'[a-z]+|x1' did not match 'x1'")
+		}
+		if !r.Match("xa") {
+			t.Fatal("'[a-z]+|x1' did not match 'xa'")
+		}
+		if !r.Match("x") {
+			t.Fatal("'[a-z]+|x1' did not match 'x'")
+		}
+		if r.Match("xy1") {
+			t.Fatal("'[a-z]+|x1' matched 'xy1'")
+		}
+
+		r = NewRegex("(?i)k|[a-z]2")
+		if !r.Match("K") || !r.Match("k2") || !r.Match("K2") || r.Match("x") {
+			t.Fatal("'(?i)k|[a-z]2' matched incorrectly")
+		}
+	}
+}
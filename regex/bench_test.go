@@ -0,0 +1,197 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// words returns the first n words of the embedded english list.
+func words(n int) []string {
+	bytes, err := lists.ReadFile("lists/word_en")
+	if err != nil {
+		panic(err)
+	}
+	var ws []string
+	for _, w := range strings.Split(string(bytes), "\n") {
+		w = strings.TrimSpace(w)
+		if w != "" {
+			ws = append(ws, Escape(w))
+		}
+		if len(ws) == n {
+			break
+		}
+	}
+	return ws
+}
+
+func BenchmarkCompileRepeat(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		NewRegex("[a-z]{1,40}")
+	}
+}
+
+func BenchmarkCompileRepeatAlternation(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		NewRegex("(ab|cd|ef|[0-9]){5,30}")
+	}
+}
+
+func BenchmarkCompileWordAlternation(b *testing.B) {
+	pattern := strings.Join(words(300), "|")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewRegex(pattern)
+	}
+}
+
+func BenchmarkCompileLexer(b *testing.B) {
+	// a lexer with 300 token types, all keywords except for the last few
+	patterns := words(295)
+	patterns = append(patterns, "[_a-zA-Z][_a-zA-Z0-9]*", "\\d+", "\\d+\\.\\d+", "\\s+", "\"[^\"]*\"")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range patterns {
+			NewRegex(p)
+		}
+	}
+}
+
+// mapMatch matches the input by looking up the transitions of the DFA in their maps
+// and trying each character in turn, as the matcher did before the table-driven form.
+func mapMatch(r *CompiledRegex, input string) bool {
+	s := r.Dfa.start
+	for _, c := range input {
+		matched := false
+		for ch, t := range r.Dfa.Trans[s] {
+			if ch.match(c) {
+				s = t
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return slices.Index(r.Dfa.final, s) != -1
+}
+
+var matchBenchmarks = []struct {
+	name    string
+	pattern string
+	input   string
+}{
+	{"Identifier", "[_a-zA-Z][_a-zA-Z0-9]*", strings.Repeat("abc_XYZ_123", 100)},
+	{"Keywords", strings.Join(words(300), "|"), "Ability"},
+	{"Unicode", "\\w(\\w|[日本語])*", "a" + strings.Repeat("日本語abc", 100)},
+	{"Email", "[a-z0-9._]+@[a-z0-9]+(\\.[a-z]+)+", strings.Repeat("john.smith", 50) + "@example.co.uk"},
+}
+
+func BenchmarkMatchTable(b *testing.B) {
+	for _, bm := range matchBenchmarks {
+		r := NewRegex(bm.pattern)
+		if !r.Match(bm.input) {
+			b.Fatal(bm.pattern, "did not match", bm.input)
+		}
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				r.Match(bm.input)
+			}
+		})
+	}
+}
+
+func BenchmarkMatchMap(b *testing.B) {
+	for _, bm := range matchBenchmarks {
+		r := NewRegex(bm.pattern)
+		if !mapMatch(r, bm.input) {
+			b.Fatal(bm.pattern, "did not match", bm.input)
+		}
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mapMatch(r, bm.input)
+			}
+		})
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	for _, bm := range matchBenchmarks {
+		r := NewRegex(bm.pattern)
+		b.Run(bm.name, func(b *testing.B) {
+			m := r.Matcher()
+			for i := 0; i < b.N; i++ {
+				m.Reset()
+				for _, c := range bm.input {
+					m.MatchNext(c)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindAll(b *testing.B) {
+	r := NewRegex("ERROR: [^\\n]*")
+	log := strings.Repeat("INFO: request served in 12ms\nERROR: disk full\n", 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.FindAllIndex(log, -1)
+	}
+}
+
+// BenchmarkFindAllLazy finds the matches of a lazy pattern, the priority of each match
+// being resolved on the text of the longest match only, not on the rest of the text.
+func BenchmarkFindAllLazy(b *testing.B) {
+	r := NewRegex("ERROR: [^\\n]*?full")
+	log := strings.Repeat("INFO: request served in 12ms\nERROR: disk full\n", 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.FindAllIndex(log, -1)
+	}
+}
+
+func BenchmarkSetMatcher(b *testing.B) {
+	// the patterns of a lexer with 300 token types, all keywords except for the last few
+	patterns := words(295)
+	patterns = append(patterns, "[_a-zA-Z][_a-zA-Z0-9]*", "\\d+", "\\d+\\.\\d+", "\\s+", "\"[^\"]*\"")
+	s := NewSet(patterns...)
+	input := "abandonment"
+	b.ResetTimer()
+	m := s.Matcher()
+	for i := 0; i < b.N; i++ {
+		m.Reset()
+		for _, c := range input {
+			m.MatchNext(c)
+		}
+	}
+}
+
+func BenchmarkUnmarshalSet(b *testing.B) {
+	// loading the set of the patterns of a lexer with 300 token types, to compare with
+	// compiling them (BenchmarkCompileSet)
+	patterns := words(295)
+	patterns = append(patterns, "[_a-zA-Z][_a-zA-Z0-9]*", "\\d+", "\\d+\\.\\d+", "\\s+", "\"[^\"]*\"")
+	data, err := NewSet(patterns...).MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var s RegexSet
+		if err := s.UnmarshalBinary(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompileSet(b *testing.B) {
+	patterns := words(295)
+	patterns = append(patterns, "[_a-zA-Z][_a-zA-Z0-9]*", "\\d+", "\\d+\\.\\d+", "\\s+", "\"[^\"]*\"")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewSet(patterns...)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"embed"
 	"math"
 	"math/rand"
+	"slices"
 	"strings"
 	"unicode"
 )
@@ -30,6 +31,11 @@ type (
 		// spanSet returns the range of characters that can be matched by this char.
 		spanSet() spanSet
 
+		// matchSet returns the exact set of characters matched by this char as a compact
+		// span set. Unlike spanSet, it is not restricted to the printable ASCII characters
+		// used for random generation when not in unicode mode.
+		matchSet() spanSet
+
 		random() string
 
 		Regex
@@ -62,6 +68,15 @@ type (
 		group   list.List // [int]
 	}
 
+	// setOperation is the difference (op '-') or the intersection (op '&') of two
+	// character sets, i.e., [left--right] or [left&&right].
+	setOperation struct {
+		mod         *modifier
+		op          rune
+		left, right char
+		group       list.List
+	}
+
 	// Matches with a list of strings. This is only used for random generation
 	// from the list of strings.
 	inList struct {
@@ -70,6 +85,15 @@ type (
 		words []string
 		group list.List // [int]
 	}
+
+	// charClass labels the transitions of a DFA. The characters on the transitions of
+	// the NFA states making up a DFA state are partitioned into disjoint classes, each
+	// leading to a single DFA state, so that any character has at most one transition.
+	charClass struct {
+		spans   spanSet
+		sources []char // the NFA characters partitioned into this class
+		group   list.List
+	}
 )
 
 //------------- The empty character -------------//
@@ -92,6 +116,10 @@ func (c *empty) nfa() *automata {
 	return nil
 }
 
+func (c *empty) emit(_ *program, next int) int {
+	return next
+}
+
 func (c *empty) match(rune) bool {
 	return false
 }
@@ -100,6 +128,10 @@ func (c *empty) spanSet() spanSet {
 	return nil
 }
 
+func (c *empty) matchSet() spanSet {
+	return nil
+}
+
 func (c *empty) random() string {
 	return ""
 }
@@ -127,6 +159,10 @@ func (c *anyChar) nfa() *automata {
 	return charNfa(c)
 }
 
+func (c *anyChar) emit(p *program, next int) int {
+	return emitChar(c, p, next)
+}
+
 func (c *anyChar) match(rune) bool {
 	return true
 }
@@ -139,6 +175,10 @@ func (c *anyChar) spanSet() spanSet {
 	}
 }
 
+func (c *anyChar) matchSet() spanSet {
+	return allUnicode
+}
+
 func (c *anyChar) random() string {
 	return string(c.spanSet().random())
 }
@@ -166,9 +206,13 @@ func (c *singleChar) nfa() *automata {
 	return charNfa(c)
 }
 
+func (c *singleChar) emit(p *program, next int) int {
+	return emitChar(c, p, next)
+}
+
 func (c *singleChar) match(char rune) bool {
 	if c.mod.caseInsensitive {
-		return unicode.ToLower(char) == unicode.ToLower(c.char)
+		return c.matchSet().match(char)
 	} else {
 		return c.char == char
 	}
@@ -190,6 +234,13 @@ func (c *singleChar) spanSet() spanSet {
 	}
 }
 
+func (c *singleChar) matchSet() spanSet {
+	if c.mod.caseInsensitive {
+		return fold(spanSet{{c.char, c.char}})
+	}
+	return spanSet{{c.char, c.char}}
+}
+
 func (c *singleChar) random() string {
 	return string(c.spanSet().random())
 }
@@ -224,17 +275,13 @@ func (c *charRange) nfa() *automata {
 	return charNfa(c)
 }
 
+func (c *charRange) emit(p *program, next int) int {
+	return emitChar(c, p, next)
+}
+
 func (c *charRange) match(char rune) bool {
 	if c.mod.caseInsensitive {
-		lf := unicode.ToLower(c.from)
-		uf := unicode.ToUpper(c.from)
-
-		lt := unicode.ToLower(c.to)
-		ut := unicode.ToUpper(c.to)
-
-		if lf != uf || lt != ut {
-			return (lf <= char && char <= lt) || (uf <= char && char <= ut)
-		}
+		return c.matchSet().match(char)
 	}
 	return c.from <= char && char <= c.to
 }
@@ -259,6 +306,16 @@ func (c *charRange) spanSet() spanSet {
 	}
 }
 
+func (c *charRange) matchSet() spanSet {
+	if c.from > c.to {
+		return nil
+	}
+	if c.mod.caseInsensitive {
+		return fold(spanSet{{c.from, c.to}})
+	}
+	return spanSet{{c.from, c.to}}
+}
+
 func (c *charRange) random() string {
 	return string(c.spanSet().random())
 }
@@ -303,6 +360,10 @@ func (c *charSet) nfa() *automata {
 	return charNfa(c)
 }
 
+func (c *charSet) emit(p *program, next int) int {
+	return emitChar(c, p, next)
+}
+
 func (c *charSet) match(ch rune) bool {
 	matched := false
 	for cs := c.sets.Front(); cs != nil; cs = cs.Next() {
@@ -333,10 +394,77 @@ func (c *charSet) spanSet() spanSet {
 	}
 }
 
+func (c *charSet) matchSet() spanSet {
+	var span spanSet
+	for cs := c.sets.Front(); cs != nil; cs = cs.Next() {
+		span = append(span, cs.Value.(char).matchSet()...)
+	}
+	if c.exclude {
+		return allUnicode.minus(span)
+	}
+	return span.compact()
+}
+
 func (c *charSet) random() string {
 	return string(c.spanSet().random())
 }
 
+//----------------- Difference and intersection of character sets ----------------//
+
+func (c *setOperation) Pattern() string {
+	return "[" + c.left.Pattern() + string(c.op) + string(c.op) + c.right.Pattern() + "]"
+}
+
+func (c *setOperation) isEmpty() bool {
+	return false
+}
+
+func (c *setOperation) groups() *list.List {
+	return &c.group
+}
+
+func (c *setOperation) setGroups(g *list.List) {
+	c.group = *g
+}
+
+func (c *setOperation) nfa() *automata {
+	return charNfa(c)
+}
+
+func (c *setOperation) emit(p *program, next int) int {
+	return emitChar(c, p, next)
+}
+
+func (c *setOperation) match(ch rune) bool {
+	if c.op == '-' {
+		return c.left.match(ch) && !c.right.match(ch)
+	}
+	return c.left.match(ch) && c.right.match(ch)
+}
+
+// spanSet returns the printable ASCII characters of the set when not in unicode
+// mode, unless it has none, in which case it returns all of them.
+func (c *setOperation) spanSet() spanSet {
+	spans := c.matchSet()
+	if !c.mod.unicode {
+		if ascii := spans.intersect(asciiPrintable); len(ascii) > 0 {
+			return ascii
+		}
+	}
+	return spans
+}
+
+func (c *setOperation) matchSet() spanSet {
+	if c.op == '-' {
+		return c.left.matchSet().minus(c.right.matchSet())
+	}
+	return c.left.matchSet().intersect(c.right.matchSet())
+}
+
+func (c *setOperation) random() string {
+	return string(c.spanSet().random())
+}
+
 //----------------- In list ----------------//
 
 func (c *inList) Pattern() string {
@@ -359,6 +487,10 @@ func (c *inList) nfa() *automata {
 	return charNfa(c)
 }
 
+func (c *inList) emit(p *program, next int) int {
+	return emitChar(c, p, next)
+}
+
 func (c *inList) match(char rune) bool {
 	return false
 }
@@ -367,6 +499,10 @@ func (c *inList) spanSet() spanSet {
 	return nil
 }
 
+func (c *inList) matchSet() spanSet {
+	return nil
+}
+
 func (c *inList) random() string {
 	if c.words == nil {
 		bytes, err := lists.ReadFile("lists/" + c.list)
@@ -381,3 +517,68 @@ func (c *inList) random() string {
 	}
 	return c.words[rand.Intn(len(c.words))]
 }
+
+//----------------- Character class of a DFA transition ----------------//
+
+func (c *charClass) Pattern() string {
+	return c.spans.pattern()
+}
+
+func (c *charClass) isEmpty() bool {
+	return false
+}
+
+func (c *charClass) groups() *list.List {
+	return &c.group
+}
+
+func (c *charClass) setGroups(g *list.List) {
+	c.group = *g
+}
+
+func (c *charClass) nfa() *automata {
+	return charNfa(c)
+}
+
+func (c *charClass) emit(p *program, next int) int {
+	return emitChar(c, p, next)
+}
+
+func (c *charClass) match(char rune) bool {
+	return c.spans.search(char)
+}
+
+// spanSet returns the characters of this class which the characters it was partitioned
+// from would generate, so that random generation stays within the same limits.
+func (c *charClass) spanSet() spanSet {
+	var span spanSet
+	for _, s := range c.sources {
+		span = append(span, s.spanSet()...)
+	}
+	return c.spans.intersect(span)
+}
+
+func (c *charClass) matchSet() spanSet {
+	return c.spans
+}
+
+func (c *charClass) random() string {
+	return string(c.spanSet().random())
+}
+
+// fold returns the span set extended with all the characters equivalent to its
+// characters under simple case folding.
+func fold(spans spanSet) spanSet {
+	folded := slices.Clone(spans)
+	for _, s := range spans {
+		for _, cr := range unicode.CaseRanges {
+			from, to := max(s.from, rune(cr.Lo)), min(s.to, rune(cr.Hi))
+			for c := from; c <= to; c++ {
+				for f := unicode.SimpleFold(c); f != c; f = unicode.SimpleFold(f) {
+					folded = append(folded, span{f, f})
+				}
+			}
+		}
+	}
+	return folded.compact()
+}
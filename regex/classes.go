@@ -0,0 +1,132 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"container/list"
+	"unicode"
+)
+
+// unicodeClass matches the characters of a unicode general category or script
+// (\p{L}, \p{Greek}, \P{Lu}) or of a POSIX class ([:alpha:]), converted from the
+// range tables of the unicode package into a span set. The span set is negated and
+// case folded when parsed.
+type unicodeClass struct {
+	mod     *modifier
+	pattern string
+	spans   spanSet
+	group   list.List
+}
+
+// posixClasses are the ASCII character classes which can be used in a character
+// set with the [:name:] syntax, as in POSIX.
+var posixClasses = map[string]spanSet{
+	"alnum":  {{'0', '9'}, {'A', 'Z'}, {'a', 'z'}},
+	"alpha":  {{'A', 'Z'}, {'a', 'z'}},
+	"ascii":  {{0, 0x7f}},
+	"blank":  {{'\t', '\t'}, {' ', ' '}},
+	"cntrl":  {{0, 0x1f}, {0x7f, 0x7f}},
+	"digit":  {{'0', '9'}},
+	"graph":  {{'!', '~'}},
+	"lower":  {{'a', 'z'}},
+	"print":  {{' ', '~'}},
+	"punct":  {{'!', '/'}, {':', '@'}, {'[', '`'}, {'{', '~'}},
+	"space":  {{'\t', '\r'}, {' ', ' '}},
+	"upper":  {{'A', 'Z'}},
+	"word":   {{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}},
+	"xdigit": {{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+}
+
+func newUnicodeClass(mod *modifier, pattern string, spans spanSet, negated bool, groups *list.List) *unicodeClass {
+	if mod.caseInsensitive {
+		spans = fold(spans)
+	}
+	if negated {
+		spans = allUnicode.minus(spans)
+	}
+	return &unicodeClass{mod, pattern, spans, cp(groups)}
+}
+
+// unicodeSpans returns the characters of the unicode general category or script
+// with the name, or nil if there is no such category or script. Any is all characters.
+func unicodeSpans(name string) spanSet {
+	if name == "Any" {
+		return allUnicode
+	}
+	table, ok := unicode.Categories[name]
+	if !ok {
+		if table, ok = unicode.Scripts[name]; !ok {
+			return nil
+		}
+	}
+	return tableSpans(table)
+}
+
+// tableSpans converts a unicode range table into a compact span set.
+func tableSpans(table *unicode.RangeTable) spanSet {
+	var spans spanSet
+	add := func(lo, hi, stride rune) {
+		if stride == 1 {
+			spans = append(spans, span{lo, hi})
+		} else {
+			for c := lo; c <= hi; c += stride {
+				spans = append(spans, span{c, c})
+			}
+		}
+	}
+	for _, r := range table.R16 {
+		add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	for _, r := range table.R32 {
+		add(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	return spans.compact()
+}
+
+func (c *unicodeClass) Pattern() string {
+	return c.pattern
+}
+
+func (c *unicodeClass) isEmpty() bool {
+	return false
+}
+
+func (c *unicodeClass) groups() *list.List {
+	return &c.group
+}
+
+func (c *unicodeClass) setGroups(g *list.List) {
+	c.group = *g
+}
+
+func (c *unicodeClass) nfa() *automata {
+	return charNfa(c)
+}
+
+func (c *unicodeClass) emit(p *program, next int) int {
+	return emitChar(c, p, next)
+}
+
+func (c *unicodeClass) match(char rune) bool {
+	return c.matchSet().search(char)
+}
+
+// spanSet returns the printable ASCII characters of the class when not in unicode
+// mode, unless it has none, such as \p{Greek}, in which case it returns all of them.
+func (c *unicodeClass) spanSet() spanSet {
+	spans := c.matchSet()
+	if !c.mod.unicode {
+		if ascii := spans.intersect(asciiPrintable); len(ascii) > 0 {
+			return ascii
+		}
+	}
+	return spans
+}
+
+func (c *unicodeClass) matchSet() spanSet {
+	return c.spans
+}
+
+func (c *unicodeClass) random() string {
+	return string(c.spanSet().random())
+}
@@ -0,0 +1,140 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnicodeClasses(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"\\p{L}+", []string{"abc", "日本語", "Ωμέγα", "é"}, []string{"a1", "_", ""}},
+		{"\\pL\\pN", []string{"a1", "é٣"}, []string{"1a"}},
+		{"\\p{Lu}", []string{"A", "Ω"}, []string{"a", "ω", "1"}},
+		{"(?i)\\p{Lu}", []string{"A", "a", "ω"}, []string{"1"}},
+		{"\\p{Greek}+", []string{"Ωμέγα"}, []string{"omega", "Ωmega"}},
+		{"\\P{Greek}+", []string{"omega"}, []string{"Ωμέγα", "Ωmega"}},
+		{"\\p{^Greek}", []string{"o"}, []string{"Ω"}},
+		{"\\P{^Greek}", []string{"Ω"}, []string{"o"}},
+		{"[\\p{L}_][\\p{L}\\p{Nd}_]*", []string{"_x1", "número_٣", "ϕ"}, []string{"1x", "a-b"}},
+		{"[^\\p{L}]", []string{"1", " "}, []string{"a", "日"}},
+		{"[[:alpha:]][[:alnum:]_]*", []string{"x_1"}, []string{"1x", "é"}},
+		{"[[:^digit:]x]+", []string{"abx"}, []string{"a1"}},
+		{"[[:upper:][:digit:]]+", []string{"A1"}, []string{"a1"}},
+		{"[\\d\\s]+", []string{"1 2\t3"}, []string{"1a"}},
+		{"[a\\]]+", []string{"a]a"}, []string{"a["}},
+		{"\\p{Any}", []string{"日"}, []string{""}},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.pattern)
+		for _, s := range test.matches {
+			if !r.Match(s) {
+				t.Errorf("%q did not match %q", test.pattern, s)
+			}
+		}
+		for _, s := range test.fails {
+			if r.Match(s) {
+				t.Errorf("%q matched %q", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestUnicodeClassPartition(t *testing.T) {
+	// overlapping unicode classes are partitioned into disjoint classes
+	r := MustCompile("\\p{Lu}x|\\p{Greek}y|\\p{L}z")
+	for _, s := range []string{"Ωx", "Ωy", "Ωz", "ωy", "az", "Ax"} {
+		if !r.Match(s) {
+			t.Errorf("%q did not match %q", r.Regex.Pattern(), s)
+		}
+	}
+	for _, s := range []string{"ωx", "Ay", "1z"} {
+		if r.Match(s) {
+			t.Errorf("%q matched %q", r.Regex.Pattern(), s)
+		}
+	}
+}
+
+func TestGenerateUnicodeClasses(t *testing.T) {
+	for _, pattern := range []string{"\\p{Greek}{3}", "\\p{Lu}\\p{Ll}+", "[[:xdigit:]]{4}", "(?u)\\p{Han}+"} {
+		r := MustCompile(pattern)
+		for range 20 {
+			if s := r.Generate(); !r.Match(s) {
+				t.Errorf("%q generated %q which it does not match", pattern, s)
+			}
+		}
+	}
+}
+
+func TestUnicodeClassErrors(t *testing.T) {
+	for _, pattern := range []string{"\\p{Klingon}", "\\p{L", "\\p", "[[:alfa:]]", "[a-\\d]"} {
+		var err *SyntaxError
+		if _, e := Compile(pattern); !errors.As(e, &err) {
+			t.Errorf("%q compiled without a syntax error", pattern)
+		}
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"[a-z--[aeiou]]+", []string{"bcd", "xyz"}, []string{"bad", "B"}},
+		{"[a-z--aeiou]+", []string{"bcd"}, []string{"bad"}},
+		{"[\\w&&[^\\d]]+", []string{"abc_", "Z"}, []string{"a1", " "}},
+		{"[\\p{L}&&\\p{Greek}]+", []string{"Ωμ"}, []string{"Ωm", "1"}},
+		{"[a-z--[aeiou]--[x-z]]+", []string{"bcd"}, []string{"a", "x"}},
+		{"[a-z&&[d-f]--e]+", []string{"df"}, []string{"e", "c"}},
+		{"[^a-z--[aeiou]]", []string{"a", "1"}, []string{"b"}},
+		{"[a[0-9]_]+", []string{"a1_"}, []string{"b"}},
+		{"[[a-c][x-z]]+", []string{"axc"}, []string{"d"}},
+		{"[[^a-z]--[0-9]]+", []string{"A_"}, []string{"a", "1"}},
+		{"(?i)[a-z--[aeiou]]", []string{"B"}, []string{"A", "e"}},
+		{"[a\\-z]+", []string{"a-z"}, []string{"b"}},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.pattern)
+		for _, s := range test.matches {
+			if !r.Match(s) {
+				t.Errorf("%q did not match %q", test.pattern, s)
+			}
+		}
+		for _, s := range test.fails {
+			if r.Match(s) {
+				t.Errorf("%q matched %q", test.pattern, s)
+			}
+		}
+		for range 10 {
+			if s := r.Generate(); !r.Match(s) {
+				t.Errorf("%q generated %q which it does not match", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestSetOperationErrors(t *testing.T) {
+	for _, pattern := range []string{"[a-z--]", "[a&&]", "[a[bc]", "[a--[b]"} {
+		var err *SyntaxError
+		if _, e := Compile(pattern); !errors.As(e, &err) {
+			t.Errorf("%q compiled without a syntax error", pattern)
+		}
+	}
+
+	// missing left and right operands
+	for _, test := range []struct {
+		pattern string
+		offset  int
+	}{{"[a&&]", 2}, {"[&&a]", 1}, {"[--a]", 1}, {"[^--a]", 2}, {"[a-z--]", 4}} {
+		var err *SyntaxError
+		if _, e := Compile(test.pattern); !errors.As(e, &err) || err.Msg != "missing operand of set operation" || err.Offset != test.offset {
+			t.Errorf("%q: expected a missing operand at offset %d, got %v", test.pattern, test.offset, e)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileValid(t *testing.T) {
+	for _, p := range []string{
+		"",
+		"abc",
+		"(ab|ac){3,5}",
+		"[a-z0-9_]+",
+		"\\d{3}-\\w*",
+		"(?i)abc",
+		"(?:ab)+(?i:c)(?iu)d(?-i)e",
+		"(:word_en)@(:word_fr)",
+		"\\(\\)\\[\\]\\*",
+	} {
+		r, err := Compile(p)
+		if err != nil {
+			t.Errorf("%q failed to compile: %v", p, err)
+		} else if r == nil {
+			t.Errorf("%q compiled to nil", p)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		offset    int
+		construct string
+	}{
+		{"ab(cd", 2, "("},
+		{"a(b(c)d", 1, "("},
+		{"ab)c", 2, ")"},
+		{"[a-z", 0, "["},
+		{"ab\\", 2, "\\"},
+		{"(?x)abc", 0, "(?x"},
+		{"(?i", 0, "(?i"},
+		{"a(?i-x:b)", 1, "(?i-x"},
+		{"(?ib)", 0, "(?ib"},
+		{"(?:ab", 0, "(?"},
+		{"(:word_xx)", 0, "(:word_xx)"},
+		{"(:word_en", 0, "(:word_en"},
+		{"a{2,3", 1, "{2,3"},
+		{"a{x}", 1, "{x}"},
+		{"a{3,2}", 1, "{3,2}"},
+		{"a{-1}", 1, "{-1}"},
+		{"*a", 0, "*"},
+		{"日本(語", 2, "("},
+		{"a\\x4", 1, "\\x4"},
+		{"\\x{110000}", 0, "\\x{110000}"},
+		{"[\\x{}]", 1, "\\x{}"},
+		{"\\x{41", 0, "\\x{41"},
+		{"\\xg0", 0, "\\xg0"},
+	}
+	for _, test := range tests {
+		r, err := Compile(test.pattern)
+		if err == nil {
+			t.Errorf("%q compiled without error", test.pattern)
+			continue
+		}
+		if r != nil {
+			t.Errorf("%q returned a compiled regex with an error", test.pattern)
+		}
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q did not return a *SyntaxError: %v", test.pattern, err)
+			continue
+		}
+		if syntaxErr.Offset != test.offset {
+			t.Errorf("%q: expected error at offset %d, got %d (%v)", test.pattern, test.offset, syntaxErr.Offset, err)
+		}
+		if syntaxErr.Construct != test.construct {
+			t.Errorf("%q: expected construct %q, got %q", test.pattern, test.construct, syntaxErr.Construct)
+		}
+		if syntaxErr.Expected == "" {
+			t.Errorf("%q: no expected hint in error", test.pattern)
+		}
+	}
+}
+
+func TestNewRegexLenient(t *testing.T) {
+	r := NewRegex("ab(cd")
+	if !r.Match("abcd") {
+		t.Error("lenient 'ab(cd' did not match 'abcd'")
+	}
+}
+
+func TestMustCompilePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustCompile did not panic on an invalid pattern")
+		}
+	}()
+	MustCompile("[abc")
+}
+
+func TestLargeRepeat(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"a{300}", []string{strings.Repeat("a", 300)}, []string{strings.Repeat("a", 299), strings.Repeat("a", 301)}},
+		{"a{,255}", []string{"", strings.Repeat("a", 255)}, []string{strings.Repeat("a", 256)}},
+		{"a{256,}", []string{strings.Repeat("a", 256), strings.Repeat("a", 1000)}, []string{strings.Repeat("a", 255)}},
+		{"(ab|c){2,1000}d", []string{"abcd", strings.Repeat("ab", 1000) + "d"}, []string{"abd", strings.Repeat("c", 1001) + "d"}},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.pattern)
+		if r.Regex.Pattern() != test.pattern {
+			t.Errorf("%q printed as %q", test.pattern, r.Regex.Pattern())
+		}
+		for _, s := range test.matches {
+			if !r.Match(s) || !r.Matcher().Match(s) || r.Submatches(s) == nil {
+				t.Errorf("%q did not match %d characters", test.pattern, len(s))
+			}
+		}
+		for _, s := range test.fails {
+			if r.Match(s) || r.Matcher().Match(s) {
+				t.Errorf("%q matched %d characters", test.pattern, len(s))
+			}
+		}
+	}
+}
+
+func TestStateBudget(t *testing.T) {
+	c := Config{Minimise: true, MaxStates: 1000}
+	for _, p := range []string{"a{400}", "(a{10}){40}", "(a|b)*a(a|b){5}"} {
+		if _, err := c.Compile(p); err != nil {
+			t.Errorf("%q failed to compile within %d states: %v", p, c.MaxStates, err)
+		}
+	}
+
+	// too many NFA states, and a small NFA with too many DFA states
+	for _, p := range []string{"a{600}", "(a{100}){100}", "(x{1000000}){1000000}", "(a|b)*a(a|b){12}"} {
+		r, err := c.Compile(p)
+		var sizeErr *SizeError
+		if !errors.As(err, &sizeErr) {
+			t.Errorf("%q did not return a *SizeError: %v", p, err)
+		} else if r != nil || sizeErr.Limit != c.MaxStates {
+			t.Errorf("%q: unexpected result %v, %v", p, r, err)
+		}
+	}
+	if _, err := Compile("(x{1000000}){1000000}"); err == nil {
+		t.Error("'(x{1000000}){1000000}' compiled with the default state budget")
+	}
+}
+
+func TestNewRegexSizePanics(t *testing.T) {
+	defer func() {
+		if _, ok := recover().(*SizeError); !ok {
+			t.Error("NewRegex did not panic with a *SizeError on a large repetition")
+		}
+	}()
+	NewRegex("(a{1000}){1000}")
+}
+
+func TestNewRegexWithoutBudget(t *testing.T) {
+	r := Config{Minimise: true}.NewRegex("a{200}")
+	if !r.Match(strings.Repeat("a", 200)) || r.Match(strings.Repeat("a", 199)) {
+		t.Error("a{200} built without a state budget should match 200 a's only")
+	}
+	defer func() {
+		if _, ok := recover().(*SizeError); !ok {
+			t.Error("Config.NewRegex did not panic with a *SizeError over its budget")
+		}
+	}()
+	Config{Minimise: true, MaxStates: 100}.NewRegex("a{200}")
+}
@@ -0,0 +1,705 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"cmp"
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Compiled regular expressions and sets are encoded, in binary or in JSON, as the
+// patterns they were compiled from together with their DFAs. Decoding parses the
+// patterns again and builds their NFAs and Pike VM programs, which takes time linear
+// in the size of the patterns, but not their DFAs, whose subset construction and
+// minimisation are the expensive steps of compilation. DFA states are numbered in the
+// breadth-first order of their transitions sorted by character, so that the encoding
+// of a regular expression does not change from one run to the next. Encodings start
+// with a header identifying the kind of value encoded and the version of the encoding,
+// which is incremented whenever it changes; data encoded with another version is
+// rejected and must be encoded again from the patterns.
+
+// EncodingVersion is the version of the binary and JSON encodings of compiled regular
+// expressions and sets.
+const EncodingVersion = 1
+
+// ErrEncoding is returned, wrapped with the details, when decoding data which is not
+// a valid encoding of the current version.
+var ErrEncoding = errors.New("regex: invalid encoding")
+
+const (
+	// regexMagic and setMagic start the binary encodings of regular expressions and sets.
+	regexMagic = "PRXR"
+	setMagic   = "PRXS"
+)
+
+type (
+	// encodedRegex is the encoded form of a compiled regular expression.
+	encodedRegex struct {
+		Version int         `json:"version,omitempty"`
+		Pattern string      `json:"pattern"`
+		Dfa     *encodedDfa `json:"dfa"`
+	}
+
+	// encodedSet is the encoded form of a set of regular expressions.
+	encodedSet struct {
+		Version int             `json:"version"`
+		Regexes []*encodedRegex `json:"regexes"`
+		Dfa     *encodedDfa     `json:"dfa"`
+	}
+
+	// encodedDfa is the encoded form of a DFA and of its table, with states numbered
+	// from 0 and the classes of characters of the table ordered by their first
+	// character.
+	encodedDfa struct {
+		States      int                 `json:"states"`
+		Classes     [][][2]rune         `json:"classes"`
+		Starts      []int               `json:"starts"`
+		Final       []int               `json:"final"`
+		Accepts     []int               `json:"accepts,omitempty"`
+		Matches     []encodedMatch      `json:"matches,omitempty"`
+		Transitions []encodedTransition `json:"transitions"`
+	}
+
+	// encodedMatch is the encoded form of the regular expressions of a set matched in
+	// a state.
+	encodedMatch struct {
+		Partial []int    `json:"partial"`
+		Full    [3][]int `json:"full"`
+	}
+
+	// encodedTransition is a transition of an encoded DFA, on classes of characters of
+	// the table, by index, or on a character used only for random generation, identified
+	// by its pattern. Generate are the characters generated on classes of characters.
+	encodedTransition struct {
+		From      int       `json:"from"`
+		To        int       `json:"to"`
+		Classes   []int     `json:"classes,omitempty"`
+		Generate  [][2]rune `json:"generate,omitempty"`
+		Generator string    `json:"generator,omitempty"`
+		Groups    []int     `json:"groups,omitempty"`
+	}
+)
+
+// MarshalBinary encodes the compiled regular expression in a compact binary form
+// which UnmarshalBinary loads without compiling it again.
+func (r *CompiledRegex) MarshalBinary() ([]byte, error) {
+	encoded, err := r.encode()
+	if err != nil {
+		return nil, err
+	}
+	e := &encoder{[]byte(regexMagic)}
+	e.uint(EncodingVersion)
+	e.regex(encoded)
+	return e.b, nil
+}
+
+// UnmarshalBinary loads a compiled regular expression encoded by MarshalBinary,
+// returning an error wrapping ErrEncoding if the data is invalid or was encoded
+// with another version.
+func (r *CompiledRegex) UnmarshalBinary(data []byte) error {
+	d, err := newDecoder(data, regexMagic)
+	if err != nil {
+		return err
+	}
+	encoded := d.regex()
+	if err := d.end(); err != nil {
+		return err
+	}
+	return r.decode(encoded)
+}
+
+// MarshalJSON encodes the compiled regular expression in JSON, with the same content
+// as MarshalBinary.
+func (r *CompiledRegex) MarshalJSON() ([]byte, error) {
+	encoded, err := r.encode()
+	if err != nil {
+		return nil, err
+	}
+	encoded.Version = EncodingVersion
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON loads a compiled regular expression encoded by MarshalJSON.
+func (r *CompiledRegex) UnmarshalJSON(data []byte) error {
+	var encoded encodedRegex
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("%w: %v", ErrEncoding, err)
+	}
+	if encoded.Version != EncodingVersion {
+		return fmt.Errorf("%w: version %d instead of %d", ErrEncoding, encoded.Version, EncodingVersion)
+	}
+	return r.decode(&encoded)
+}
+
+// MarshalBinary encodes the set of regular expressions in a compact binary form which
+// UnmarshalBinary loads without compiling it again.
+func (s *RegexSet) MarshalBinary() ([]byte, error) {
+	encoded, err := s.encode()
+	if err != nil {
+		return nil, err
+	}
+	e := &encoder{[]byte(setMagic)}
+	e.uint(EncodingVersion)
+	e.uint(len(encoded.Regexes))
+	for _, r := range encoded.Regexes {
+		e.regex(r)
+	}
+	e.dfa(encoded.Dfa)
+	return e.b, nil
+}
+
+// UnmarshalBinary loads a set of regular expressions encoded by MarshalBinary,
+// returning an error wrapping ErrEncoding if the data is invalid or was encoded with
+// another version.
+func (s *RegexSet) UnmarshalBinary(data []byte) error {
+	d, err := newDecoder(data, setMagic)
+	if err != nil {
+		return err
+	}
+	encoded := &encodedSet{Version: EncodingVersion}
+	encoded.Regexes = make([]*encodedRegex, d.length())
+	for i := range encoded.Regexes {
+		encoded.Regexes[i] = d.regex()
+	}
+	encoded.Dfa = d.dfa()
+	if err := d.end(); err != nil {
+		return err
+	}
+	return s.decode(encoded)
+}
+
+// MarshalJSON encodes the set of regular expressions in JSON, with the same content as
+// MarshalBinary.
+func (s *RegexSet) MarshalJSON() ([]byte, error) {
+	encoded, err := s.encode()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON loads a set of regular expressions encoded by MarshalJSON.
+func (s *RegexSet) UnmarshalJSON(data []byte) error {
+	var encoded encodedSet
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("%w: %v", ErrEncoding, err)
+	}
+	if encoded.Version != EncodingVersion {
+		return fmt.Errorf("%w: version %d instead of %d", ErrEncoding, encoded.Version, EncodingVersion)
+	}
+	return s.decode(&encoded)
+}
+
+// encode returns the encoded form of the regular expression, or the syntax error of its
+// pattern, which NewRegex compiles leniently but decoding would reject.
+func (r *CompiledRegex) encode() (*encodedRegex, error) {
+	p := newParser(r.source)
+	if p.parse(); p.err != nil {
+		return nil, p.err
+	}
+	return &encodedRegex{Pattern: r.source, Dfa: encodeDfa(r.Dfa, r.table)}, nil
+}
+
+// decode sets the regular expression to the one encoded, parsing its pattern again.
+// The DFA is checked against the pattern only as far as its size, assertions and
+// groups go, so that invalid data cannot make matching fail.
+func (r *CompiledRegex) decode(encoded *encodedRegex) error {
+	if encoded.Dfa == nil {
+		return fmt.Errorf("%w: no DFA", ErrEncoding)
+	}
+	p := newParser(encoded.Pattern)
+	re := p.parse()
+	if p.err != nil {
+		return fmt.Errorf("%w: %v", ErrEncoding, p.err)
+	}
+	n := re.nfa()
+	d, t, err := encoded.Dfa.automata(generators(n), -1, len(p.names))
+	if err != nil {
+		return err
+	}
+	*r = CompiledRegex{re, n, d, t, newProgram(re, len(p.names)-1), p.names, encoded.Pattern, DefaultConfig}
+	return nil
+}
+
+func (s *RegexSet) encode() (*encodedSet, error) {
+	encoded := &encodedSet{Version: EncodingVersion, Dfa: encodeDfa(s.Dfa, s.table)}
+	for _, r := range s.Regexes {
+		e, err := r.encode()
+		if err != nil {
+			return nil, err
+		}
+		encoded.Regexes = append(encoded.Regexes, e)
+	}
+	return encoded, nil
+}
+
+func (s *RegexSet) decode(encoded *encodedSet) error {
+	if encoded.Dfa == nil {
+		return fmt.Errorf("%w: no DFA", ErrEncoding)
+	}
+	regexes := make([]*CompiledRegex, len(encoded.Regexes))
+	groups := 0
+	for i, e := range encoded.Regexes {
+		regexes[i] = &CompiledRegex{}
+		if err := regexes[i].decode(e); err != nil {
+			return err
+		}
+		groups = max(groups, len(regexes[i].names))
+	}
+	n := setNfa(regexes)
+	d, t, err := encoded.Dfa.automata(generators(n), len(regexes), groups)
+	if err != nil {
+		return err
+	}
+	*s = RegexSet{regexes, n, d, t}
+	return nil
+}
+
+// encodeDfa numbers the states of the DFA in the breadth-first order of its
+// transitions sorted by character, from its start states, and encodes it with the
+// classes of characters of its table.
+func encodeDfa(auto *automata, t *table) *encodedDfa {
+	e := &encodedDfa{Starts: []int{}, Final: []int{}, Transitions: []encodedTransition{}}
+	classes := t.classSpans()
+	slices.SortFunc(classes, func(a, b spanSet) int {
+		return cmp.Compare(a[0].from, b[0].from)
+	})
+	for _, c := range classes {
+		e.Classes = append(e.Classes, encodeSpans(c))
+	}
+
+	index := map[state]int{}
+	var states []state
+	visit := func(s state) {
+		if _, ok := index[s]; !ok {
+			index[s] = len(states)
+			states = append(states, s)
+		}
+	}
+	for _, s := range auto.startStates() {
+		visit(s)
+	}
+	for i := 0; i < len(states); i++ {
+		trans := auto.Trans[states[i]]
+		for _, c := range sortedChars(trans) {
+			visit(trans[c])
+			t := encodedTransition{From: i, To: index[trans[c]]}
+			for g := c.groups().Front(); g != nil; g = g.Next() {
+				t.Groups = append(t.Groups, g.Value.(int))
+			}
+			if cc, ok := c.(*charClass); ok {
+				for k, class := range classes {
+					if cc.spans.search(class[0].from) {
+						t.Classes = append(t.Classes, k)
+					}
+				}
+				t.Generate = encodeSpans(cc.spanSet())
+			} else {
+				t.Generator = c.Pattern()
+			}
+			e.Transitions = append(e.Transitions, t)
+		}
+	}
+	e.States = len(states)
+	for _, s := range auto.startStates() {
+		e.Starts = append(e.Starts, index[s])
+	}
+	for _, f := range auto.final {
+		if i, ok := index[f]; ok {
+			e.Final = append(e.Final, i)
+		}
+	}
+	slices.Sort(e.Final)
+	if auto.accepts != nil {
+		e.Accepts = make([]int, len(states))
+		for i, s := range states {
+			e.Accepts[i] = int(auto.accepts[s])
+		}
+	}
+	if auto.matches != nil {
+		e.Matches = make([]encodedMatch, len(states))
+		for i, s := range states {
+			if m := auto.matches[s]; m != nil {
+				e.Matches[i] = encodedMatch{m.partial, m.full}
+			}
+		}
+	}
+	return e
+}
+
+// sortedChars returns the characters of the transitions of a DFA state, the classes
+// of characters first, by their first character, followed by the characters used
+// only for generation, by pattern.
+func sortedChars(trans map[char]state) []char {
+	var chars []char
+	for c := range trans {
+		chars = append(chars, c)
+	}
+	slices.SortFunc(chars, func(a, b char) int {
+		ca, aClass := a.(*charClass)
+		cb, bClass := b.(*charClass)
+		switch {
+		case aClass && bClass:
+			return cmp.Compare(ca.spans[0].from, cb.spans[0].from)
+		case aClass:
+			return -1
+		case bClass:
+			return 1
+		}
+		return cmp.Compare(a.Pattern(), b.Pattern())
+	})
+	return chars
+}
+
+// automata returns the DFA encoded and its table, built directly from the classes of
+// characters encoded. Transitions on characters used only for generation are on the
+// characters of the NFA with the same patterns. Regexes is the number of regular
+// expressions of a set, whose matches are encoded in each state, or -1 for a regular
+// expression, and groups the number of capture groups, group 0 included.
+func (e *encodedDfa) automata(generators map[string]char, regexes int, groups int) (*automata, *table, error) {
+	valid := func(s int) bool {
+		return s >= 0 && s < e.States
+	}
+	// states other than the start states are reached by at least one transition
+	if e.States <= 0 || e.States > len(e.Transitions)+contexts || len(e.Starts) != contexts ||
+		(e.Accepts != nil && len(e.Accepts) != e.States) ||
+		(e.Matches == nil) != (regexes < 0) || (e.Matches != nil && len(e.Matches) != e.States) {
+		return nil, nil, fmt.Errorf("%w: inconsistent DFA", ErrEncoding)
+	}
+	for _, a := range e.Accepts {
+		if a < 0 || a >= 1<<(beforeWord+1) {
+			return nil, nil, fmt.Errorf("%w: lookaheads %d", ErrEncoding, a)
+		}
+	}
+	for _, m := range e.Matches {
+		for _, indices := range [][]int{m.Partial, m.Full[beforeEnd], m.Full[beforeOther], m.Full[beforeWord]} {
+			for _, i := range indices {
+				if i < 0 || i >= regexes {
+					return nil, nil, fmt.Errorf("%w: regular expression %d of %d", ErrEncoding, i, regexes)
+				}
+			}
+		}
+	}
+	classes := make([]spanSet, len(e.Classes))
+	for i, c := range e.Classes {
+		if len(c) == 0 {
+			return nil, nil, fmt.Errorf("%w: empty class of characters", ErrEncoding)
+		}
+		classes[i] = decodeSpans(c)
+	}
+	states := make([]state, e.States)
+	for i := range states {
+		states[i] = newState()
+	}
+	auto := &automata{Trans: make(transitions), final: []state{}}
+	t := &table{states: states, final: make([]bool, e.States)}
+	t.setClasses(classes)
+	t.next = make([]int32, e.States*t.classes)
+	t.groups = make([][]int, e.States*t.classes)
+	for i := range t.next {
+		t.next[i] = -1
+	}
+	for _, tr := range e.Transitions {
+		if !valid(tr.From) || !valid(tr.To) {
+			return nil, nil, fmt.Errorf("%w: transition from %d to %d", ErrEncoding, tr.From, tr.To)
+		}
+		for _, g := range tr.Groups {
+			if g < 0 || g >= groups {
+				return nil, nil, fmt.Errorf("%w: group %d of %d", ErrEncoding, g, groups)
+			}
+		}
+		var c char
+		if tr.Generator != "" {
+			if c = generators[tr.Generator]; c == nil {
+				return nil, nil, fmt.Errorf("%w: unknown generator %s", ErrEncoding, tr.Generator)
+			}
+		} else {
+			if len(tr.Classes) == 0 {
+				return nil, nil, fmt.Errorf("%w: transition without characters", ErrEncoding)
+			}
+			cc := &charClass{}
+			for _, k := range tr.Classes {
+				if k < 0 || k >= t.classes {
+					return nil, nil, fmt.Errorf("%w: class of characters %d", ErrEncoding, k)
+				}
+				cc.spans = append(cc.spans, classes[k]...)
+				t.next[tr.From*t.classes+k] = int32(tr.To)
+				t.groups[tr.From*t.classes+k] = tr.Groups
+			}
+			cc.spans = cc.spans.compact()
+			if len(tr.Generate) > 0 {
+				// the characters generated by the class, which are those of the NFA
+				// characters partitioned into it when it is compiled
+				cc.sources = []char{&unicodeClass{mod: &modifier{unicode: true}, spans: decodeSpans(tr.Generate)}}
+			}
+			c = cc
+		}
+		groups := list.New()
+		for _, g := range tr.Groups {
+			groups.PushBack(g)
+		}
+		c.setGroups(groups)
+		auto.addTransitions(states[tr.From], map[char]state{c: states[tr.To]})
+	}
+	for ctx, s := range e.Starts {
+		if !valid(s) {
+			return nil, nil, fmt.Errorf("%w: start state %d", ErrEncoding, s)
+		}
+		auto.starts = append(auto.starts, states[s])
+		t.starts[ctx] = int32(s)
+	}
+	auto.start = auto.starts[textStart]
+	for _, f := range e.Final {
+		if !valid(f) {
+			return nil, nil, fmt.Errorf("%w: final state %d", ErrEncoding, f)
+		}
+		auto.final = append(auto.final, states[f])
+		t.final[f] = true
+	}
+	if e.Accepts != nil {
+		auto.accepts = map[state]uint8{}
+		t.accepts = make([]uint8, e.States)
+		for i, a := range e.Accepts {
+			auto.accepts[states[i]] = uint8(a)
+			t.accepts[i] = uint8(a)
+		}
+	}
+	if e.Matches != nil {
+		auto.matches = map[state]*setMatch{}
+		t.matches = make([]*setMatch, e.States)
+		for i, m := range e.Matches {
+			t.matches[i] = &setMatch{m.Partial, m.Full}
+			auto.matches[states[i]] = t.matches[i]
+		}
+	}
+	return auto, t, nil
+}
+
+// generators returns the characters of the NFA used only for random generation, such
+// as lists of words, by pattern.
+func generators(nfa *automata) map[string]char {
+	g := map[string]char{}
+	for _, trans := range nfa.Trans {
+		for c := range trans {
+			if _, ok := c.(*assertion); !ok && !c.isEmpty() && len(c.matchSet()) == 0 {
+				g[c.Pattern()] = c
+			}
+		}
+	}
+	return g
+}
+
+func encodeSpans(spans spanSet) [][2]rune {
+	var encoded [][2]rune
+	for _, s := range spans {
+		encoded = append(encoded, [2]rune{s.from, s.to})
+	}
+	return encoded
+}
+
+func decodeSpans(encoded [][2]rune) spanSet {
+	spans := make(spanSet, len(encoded))
+	for i, s := range encoded {
+		spans[i] = span{s[0], s[1]}
+	}
+	return spans
+}
+
+// encoder appends the binary encoding of values, integers being encoded as varints.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) uint(v int) {
+	e.b = binary.AppendUvarint(e.b, uint64(v))
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) ints(values []int) {
+	e.uint(len(values))
+	for _, v := range values {
+		e.uint(v)
+	}
+}
+
+func (e *encoder) spans(spans [][2]rune) {
+	e.uint(len(spans))
+	for _, s := range spans {
+		e.uint(int(s[0]))
+		e.uint(int(s[1]))
+	}
+}
+
+// flag encodes whether an optional part of the encoding is present.
+func (e *encoder) flag(present bool) {
+	if present {
+		e.uint(1)
+	} else {
+		e.uint(0)
+	}
+}
+
+func (e *encoder) regex(r *encodedRegex) {
+	e.string(r.Pattern)
+	e.dfa(r.Dfa)
+}
+
+func (e *encoder) dfa(d *encodedDfa) {
+	e.uint(d.States)
+	e.uint(len(d.Classes))
+	for _, c := range d.Classes {
+		e.spans(c)
+	}
+	e.ints(d.Starts)
+	e.ints(d.Final)
+	e.flag(d.Accepts != nil)
+	if d.Accepts != nil {
+		e.ints(d.Accepts)
+	}
+	e.flag(d.Matches != nil)
+	for _, m := range d.Matches {
+		e.ints(m.Partial)
+		for _, full := range m.Full {
+			e.ints(full)
+		}
+	}
+	e.uint(len(d.Transitions))
+	for _, t := range d.Transitions {
+		e.uint(t.From)
+		e.uint(t.To)
+		e.ints(t.Classes)
+		e.spans(t.Generate)
+		e.string(t.Generator)
+		e.ints(t.Groups)
+	}
+}
+
+// decoder reads values encoded by an encoder, recording the first error encountered,
+// after which it returns zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// newDecoder returns a decoder of the data after checking its header.
+func newDecoder(data []byte, magic string) (*decoder, error) {
+	if len(data) < len(magic) || string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: missing header %s", ErrEncoding, magic)
+	}
+	d := &decoder{b: data[len(magic):]}
+	if version := d.uint(); d.err == nil && version != EncodingVersion {
+		return nil, fmt.Errorf("%w: version %d instead of %d", ErrEncoding, version, EncodingVersion)
+	}
+	return d, d.err
+}
+
+func (d *decoder) uint() int {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 || v > 1<<31 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return int(v)
+}
+
+// length reads the length of a sequence, which cannot be longer than the data left.
+func (d *decoder) length() int {
+	n := d.uint()
+	if n > len(d.b) {
+		d.fail()
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) string() string {
+	n := d.length()
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *decoder) ints() []int {
+	values := make([]int, d.length())
+	for i := range values {
+		values[i] = d.uint()
+	}
+	return values
+}
+
+func (d *decoder) spans() [][2]rune {
+	spans := make([][2]rune, d.length())
+	for i := range spans {
+		spans[i] = [2]rune{rune(d.uint()), rune(d.uint())}
+	}
+	return spans
+}
+
+func (d *decoder) flag() bool {
+	return d.uint() != 0
+}
+
+func (d *decoder) regex() *encodedRegex {
+	return &encodedRegex{Pattern: d.string(), Dfa: d.dfa()}
+}
+
+func (d *decoder) dfa() *encodedDfa {
+	e := &encodedDfa{States: d.uint()}
+	if e.States > len(d.b)+contexts {
+		d.fail()
+	}
+	e.Classes = make([][][2]rune, d.length())
+	for i := range e.Classes {
+		e.Classes[i] = d.spans()
+	}
+	e.Starts, e.Final = d.ints(), d.ints()
+	if d.flag() {
+		e.Accepts = d.ints()
+	}
+	if d.flag() {
+		e.Matches = make([]encodedMatch, e.States)
+		for i := range e.Matches {
+			if d.err != nil {
+				break
+			}
+			e.Matches[i].Partial = d.ints()
+			for j := range e.Matches[i].Full {
+				e.Matches[i].Full[j] = d.ints()
+			}
+		}
+	}
+	e.Transitions = make([]encodedTransition, d.length())
+	for i := range e.Transitions {
+		t := &e.Transitions[i]
+		t.From, t.To = d.uint(), d.uint()
+		t.Classes, t.Generate = d.ints(), d.spans()
+		t.Generator = d.string()
+		t.Groups = d.ints()
+	}
+	return e
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: truncated or corrupt data", ErrEncoding)
+	}
+	d.b = nil
+}
+
+// end returns the first error of the decoder, or an error if there is data left.
+func (d *decoder) end() error {
+	if d.err == nil && len(d.b) > 0 {
+		d.fail()
+	}
+	return d.err
+}
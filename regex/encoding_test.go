@@ -0,0 +1,210 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var encodingPatterns = []string{
+	"",
+	"abc",
+	"(ab|ac){3,5}",
+	"(?<year>\\d{4})-(?<month>\\d\\d)",
+	"[\\p{L}_][\\p{L}\\d_]*",
+	"\\bif\\b|[a-z]+$",
+	"<!--.*?-->",
+	"(?i)select",
+	"(:word_en)@(:word_fr)\\.com",
+}
+
+var encodingTexts = []string{"", "abc", "abacab", "1999-12", "if iffy x", "<!-- a --> -->", "SeLect", "日本_1", "cd"}
+
+func TestMarshalBinary(t *testing.T) {
+	for _, pattern := range encodingPatterns {
+		r := NewRegex(pattern)
+		data, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loaded CompiledRegex
+		if err := loaded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%q: %v", pattern, err)
+		}
+		sameRegex(t, pattern, r, &loaded)
+
+		// the encoding is stable
+		again, _ := loaded.MarshalBinary()
+		if !bytes.Equal(data, again) {
+			t.Errorf("%q: encoding of the loaded regex differs", pattern)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	for _, pattern := range encodingPatterns {
+		r := NewRegex(pattern)
+		data, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loaded CompiledRegex
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			t.Fatalf("%q: %v", pattern, err)
+		}
+		sameRegex(t, pattern, r, &loaded)
+	}
+}
+
+// sameRegex checks that the loaded regex matches the texts as the compiled one does.
+func sameRegex(t *testing.T, pattern string, r *CompiledRegex, loaded *CompiledRegex) {
+	if loaded.Regex.Pattern() != r.Regex.Pattern() || !slices.Equal(loaded.SubexpNames(), r.SubexpNames()) {
+		t.Errorf("%q loaded as %q", pattern, loaded.Regex.Pattern())
+	}
+	if loaded.Dfa.StateCount() != r.Dfa.StateCount() || loaded.Dfa.TransitionCount() != r.Dfa.TransitionCount() {
+		t.Errorf("%q: loaded DFA differs", pattern)
+	}
+	for _, text := range encodingTexts {
+		if loaded.Match(text) != r.Match(text) || loaded.Matcher().Match(text) != r.Matcher().Match(text) {
+			t.Errorf("%q: loaded regex matches %q differently", pattern, text)
+		}
+		if !equalSpans(spans(loaded.Submatches(text)), spans(r.Submatches(text))) {
+			t.Errorf("%q: loaded regex has different submatches in %q", pattern, text)
+		}
+		if !slices.Equal(loaded.FindAll(text, -1), r.FindAll(text, -1)) {
+			t.Errorf("%q: loaded regex finds %v in %q", pattern, loaded.FindAll(text, -1), text)
+		}
+	}
+	for range 10 {
+		// lists of words are only used for generation and never match
+		s := loaded.Generate()
+		if strings.Contains(pattern, "(:") {
+			if !strings.Contains(s, "@") || !strings.HasSuffix(s, ".com") || len(s) < 7 {
+				t.Errorf("%q: loaded regex generated %q", pattern, s)
+			}
+		} else if !r.Match(s) {
+			t.Errorf("%q: loaded regex generated %q", pattern, s)
+		}
+	}
+}
+
+func TestMarshalSet(t *testing.T) {
+	s := MustCompileSet("let", "[a-z]+", "\\d+", "\\bif\\b", "/\\*(.|\\n)*?\\*/")
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded, loadedJSON RegexSet
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(jsonData, &loadedJSON); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"let", "lets", "12", "if", "/* a */", "/* a */ */", "?"} {
+		for _, l := range []*RegexSet{&loaded, &loadedJSON} {
+			if !slices.Equal(l.Match(text), s.Match(text)) {
+				t.Errorf("%q: loaded set matched %v instead of %v", text, l.Match(text), s.Match(text))
+			}
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	data, _ := MustCompile("a+b").MarshalBinary()
+	var r CompiledRegex
+	for _, invalid := range [][]byte{
+		nil,
+		[]byte("PRXS\x01"),
+		append([]byte("PRXR\x02"), data[5:]...),
+		data[:len(data)-1],
+		append(slices.Clone(data), 0),
+	} {
+		if err := r.UnmarshalBinary(invalid); !errors.Is(err, ErrEncoding) {
+			t.Errorf("%q: expected an encoding error, got %v", invalid, err)
+		}
+	}
+
+	// patterns with syntax errors, which NewRegex compiles, are neither encoded nor decoded
+	var syntaxErr *SyntaxError
+	if _, err := NewRegex("ab(cd").MarshalBinary(); !errors.As(err, &syntaxErr) {
+		t.Error("expected a syntax error, got", err)
+	}
+	data, _ = MustCompile("abcd").MarshalBinary()
+	data = bytes.Replace(data, []byte("abcd"), []byte("ab(d"), 1)
+	if err := r.UnmarshalBinary(data); !errors.Is(err, ErrEncoding) {
+		t.Error("expected an encoding error, got", err)
+	}
+	if err := json.Unmarshal([]byte(`{"version":99,"pattern":"a","dfa":{}}`), &r); !errors.Is(err, ErrEncoding) {
+		t.Error("expected a version error, got", err)
+	}
+	if err := json.Unmarshal([]byte(`{"version":1,"pattern":"a","dfa":{"states":1000000,"starts":[0,0,0]}}`), &r); !errors.Is(err, ErrEncoding) {
+		t.Error("expected an encoding error, got", err)
+	}
+}
+
+func TestUnmarshalCorrupt(t *testing.T) {
+	// data changed by a byte is either rejected or loads a regex which can be used
+	texts := []string{"", "abcxyz", "bbaczz c", "if iffy ab", "12-3 x", "let /* a */ lets"}
+	corrupt := func(data []byte, load func([]byte) error, use func(string)) {
+		for i := range data {
+			for _, v := range []byte{data[i] ^ 1, data[i] ^ 0x80, data[i] + 1, data[i] - 1, 0, '*', '?', '(', '|'} {
+				if v == data[i] {
+					continue
+				}
+				changed := slices.Clone(data)
+				changed[i] = v
+				if err := load(changed); err != nil {
+					if !errors.Is(err, ErrEncoding) {
+						t.Errorf("%q: expected an encoding error, got %v", changed, err)
+					}
+					continue
+				}
+				func() {
+					defer func() {
+						if p := recover(); p != nil {
+							t.Errorf("%q: loaded with no error and panicked with %v", changed, p)
+						}
+					}()
+					for _, text := range texts {
+						use(text)
+					}
+				}()
+			}
+		}
+	}
+
+	for _, pattern := range []string{"(a|b)*c[x-z]+", "(a|b)*?c[x-z]+", "\\bif\\b|[a-z]+$", "(?<y>\\d{2})-(?<m>\\d)"} {
+		data, _ := MustCompile(pattern).MarshalBinary()
+		var r CompiledRegex
+		corrupt(data, r.UnmarshalBinary, func(text string) {
+			r.Match(text)
+			r.Matcher().Match(text)
+			r.FindAllIndex(text, -1)
+			r.FindSubmatches(text)
+			r.NamedSubmatches(text)
+			_, _ = r.FindReader(strings.NewReader(text))
+		})
+	}
+
+	data, _ := MustCompileSet("let", "[a-z]+", "\\bif\\b", "/\\*.*?\\*/").MarshalBinary()
+	var s RegexSet
+	corrupt(data, s.UnmarshalBinary, func(text string) {
+		s.Match(text)
+		m := s.Matcher()
+		for _, c := range text {
+			m.MatchNext(c)
+			m.Partial()
+			m.FullBefore(c)
+		}
+	})
+}
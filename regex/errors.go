@@ -0,0 +1,49 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import "strconv"
+
+// SyntaxError describes a malformed regular expression detected by Compile. It
+// records the rune offset of the offending construct in the pattern, the construct
+// itself and a hint of what the parser expected to find instead.
+type SyntaxError struct {
+	// Pattern is the complete regular expression being compiled.
+	Pattern string
+
+	// Offset is the position, in runes, of the offending construct in Pattern.
+	Offset int
+
+	// Construct is the part of the pattern that could not be parsed, e.g. "(" or "(?x".
+	Construct string
+
+	// Msg describes the problem.
+	Msg string
+
+	// Expected is a hint of what was expected at the point of the error.
+	Expected string
+}
+
+func (e *SyntaxError) Error() string {
+	msg := "regex: " + e.Msg + " at offset " + strconv.Itoa(e.Offset) +
+		" (" + strconv.Quote(e.Construct) + ") in " + strconv.Quote(e.Pattern)
+	if e.Expected != "" {
+		msg += ": expected " + e.Expected
+	}
+	return msg
+}
+
+// SizeError describes a regular expression rejected by Compile because its automata
+// would have more states than the state budget of the configuration (Config.MaxStates),
+// usually because of large or nested counted repetitions such as (a{1000}){1000}.
+type SizeError struct {
+	// Pattern is the regular expression being compiled.
+	Pattern string
+
+	// Limit is the state budget which was exceeded.
+	Limit int
+}
+
+func (e *SizeError) Error() string {
+	return "regex: " + strconv.Quote(e.Pattern) + " needs more than " + strconv.Itoa(e.Limit) + " states"
+}
@@ -0,0 +1,46 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"cmp"
+	"slices"
+)
+
+// CharClass is a class of characters, described by the pattern matching one of them
+// and by the ranges of characters, inclusive and in order, that it contains.
+type CharClass struct {
+	Pattern string
+	Ranges  [][2]rune
+}
+
+// Contains returns true if the character is in the class.
+func (c CharClass) Contains(r rune) bool {
+	i, found := slices.BinarySearchFunc(c.Ranges, r, func(s [2]rune, r rune) int {
+		return cmp.Compare(s[1], r)
+	})
+	return found || i < len(c.Ranges) && c.Ranges[i][0] <= r
+}
+
+// Expected returns the classes of the characters which can follow the text matched so
+// far, in the order of their first character. It is empty after the last character
+// could not be matched, or if the text matched can not be extended.
+func (m *Matcher) Expected() []CharClass {
+	if m.LastMatch == NoMatch {
+		return nil
+	}
+	var classes []CharClass
+	for c := range m.Compiled.Dfa.Trans[m.State] {
+		class := CharClass{Pattern: c.Pattern()}
+		for _, s := range c.matchSet() {
+			class.Ranges = append(class.Ranges, [2]rune{s.from, s.to})
+		}
+		if len(class.Ranges) > 0 {
+			classes = append(classes, class)
+		}
+	}
+	slices.SortFunc(classes, func(a, b CharClass) int {
+		return cmp.Compare(a.Ranges[0][0], b.Ranges[0][0])
+	})
+	return classes
+}
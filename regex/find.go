@@ -0,0 +1,256 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// Location is the position of a match found in a text by the Find methods of
+// CompiledRegex.
+type Location struct {
+	// Text is the matched text.
+	Text string
+
+	// Start and End are the byte offsets of the match in the text; End is exclusive.
+	Start, End int
+
+	// RuneStart and RuneEnd are the offsets of the match in the text in runes; RuneEnd
+	// is exclusive.
+	RuneStart, RuneEnd int
+}
+
+// Find returns the text of the leftmost-longest match of the regular expression in
+// the text, or the empty string if there is no match (which is indistinguishable
+// from an empty match; use FindIndex to tell them apart).
+func (r *CompiledRegex) Find(text string) string {
+	if loc := r.FindIndex(text); loc != nil {
+		return loc.Text
+	}
+	return ""
+}
+
+// FindIndex returns the location of the leftmost-longest match of the regular
+// expression in the text, or nil if there is no match. The match starting at the
+// lowest offset is chosen and, amongst the matches starting there, the longest. For
+// regular expressions with lazy quantifiers, the match starting there is chosen by
+// priority, as in Perl, instead: lazy quantifiers prefer fewer repetitions and
+// alternatives on the left are preferred, so that '<!--.*?-->' finds the first
+// comment only.
+// Every starting position is tried in turn so that the search can be quadratic
+// on the length of text for regular expressions matching long prefixes that fail.
+func (r *CompiledRegex) FindIndex(text string) *Location {
+	return r.findFrom(text, 0, 0)
+}
+
+// FindSubmatches returns the locations of the capture groups of the leftmost-longest
+// match of the regular expression in the text, indexed by group number with group 0
+// being the whole match, or nil if there is no match. The offsets are relative to
+// the start of the text.
+func (r *CompiledRegex) FindSubmatches(text string) []*Location {
+	loc := r.FindIndex(text)
+	if loc == nil {
+		return nil
+	}
+	before, after := rune(-1), rune(-1)
+	if loc.Start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(text[:loc.Start])
+	}
+	if loc.End < len(text) {
+		after, _ = utf8.DecodeRuneInString(text[loc.End:])
+	}
+	return locations(r.program.submatches([]rune(loc.Text), before, after), loc.Text, loc.Start, loc.RuneStart)
+}
+
+// FindAll returns the text of the successive non-overlapping leftmost-longest matches
+// of the regular expression in the text. If n >= 0, at most n matches are returned.
+func (r *CompiledRegex) FindAll(text string, n int) []string {
+	var found []string
+	for _, loc := range r.FindAllIndex(text, n) {
+		found = append(found, loc.Text)
+	}
+	return found
+}
+
+// FindAllIndex returns the locations of the successive non-overlapping leftmost-longest
+// matches of the regular expression in the text. If n >= 0, at most n matches are
+// returned. As in the standard regexp package, an empty match immediately after a
+// previous match is ignored.
+func (r *CompiledRegex) FindAllIndex(text string, n int) []*Location {
+	var found []*Location
+	start, runeStart := 0, 0
+	previousEnd := -1
+	for n < 0 || len(found) < n {
+		loc := r.findFrom(text, start, runeStart)
+		if loc == nil {
+			break
+		}
+		if loc.End == loc.Start && loc.Start == previousEnd {
+			// skip the empty match adjacent to the previous one
+			if loc.Start == len(text) {
+				break
+			}
+			_, size := utf8.DecodeRuneInString(text[loc.Start:])
+			start, runeStart = loc.Start+size, loc.RuneStart+1
+			continue
+		}
+		found = append(found, loc)
+		previousEnd = loc.End
+		if loc.End > loc.Start {
+			start, runeStart = loc.End, loc.RuneEnd
+		} else if loc.End < len(text) {
+			_, size := utf8.DecodeRuneInString(text[loc.End:])
+			start, runeStart = loc.End+size, loc.RuneEnd+1
+		} else {
+			break
+		}
+	}
+	return found
+}
+
+// FindReader returns the location of the leftmost-longest match of the regular
+// expression in the runes read from the reader, or nil if there is no match. The
+// reader is read up to the end of the match or further, and only the runes since
+// the current starting position are kept in memory. An error other than io.EOF
+// returned by the reader is returned.
+func (r *CompiledRegex) FindReader(in io.RuneReader) (*Location, error) {
+	t := r.table
+	var runes []rune
+	var sizes []int
+	start, runeStart := 0, 0
+	previous := rune(-1)
+	eof := false
+	var err error
+	read := func() bool {
+		if eof {
+			return false
+		}
+		c, size, e := in.ReadRune()
+		if e != nil {
+			eof = true
+			if e != io.EOF {
+				err = e
+			}
+			return false
+		}
+		runes = append(runes, c)
+		sizes = append(sizes, size)
+		return true
+	}
+
+	for {
+		end := -1
+		s := t.startAfter(previous)
+		for i := 0; ; i++ {
+			if i == len(runes) && !read() {
+				if t.final[s] {
+					end = i
+				}
+				break
+			}
+			if t.acceptsBefore(s, runes[i]) {
+				end = i
+			}
+			if s = t.step(s, runes[i]); s == -1 {
+				break
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if end >= 0 && r.program.lazy {
+			after := rune(-1)
+			if end < len(runes) {
+				after = runes[end]
+			}
+			end = r.program.firstEnd(runes[:end], previous, after)
+		}
+		if end >= 0 {
+			length := 0
+			for _, size := range sizes[:end] {
+				length += size
+			}
+			return &Location{
+				Text:      string(runes[:end]),
+				Start:     start,
+				End:       start + length,
+				RuneStart: runeStart,
+				RuneEnd:   runeStart + end,
+			}, nil
+		}
+		if len(runes) == 0 {
+			return nil, nil
+		}
+		start, runeStart = start+sizes[0], runeStart+1
+		previous = runes[0]
+		runes, sizes = runes[1:], sizes[1:]
+	}
+}
+
+// findFrom returns the leftmost-longest match starting at or after the byte offset
+// start, which is at the rune offset runeStart, or nil if there is none.
+func (r *CompiledRegex) findFrom(text string, start int, runeStart int) *Location {
+	t := r.table
+	previous := rune(-1)
+	if start > 0 {
+		previous, _ = utf8.DecodeLastRuneInString(text[:start])
+	}
+	for {
+		end, runeEnd := -1, -1
+		s := t.startAfter(previous)
+		position, runePosition := start, runeStart
+		for {
+			if position == len(text) {
+				if t.final[s] {
+					end, runeEnd = position, runePosition
+				}
+				break
+			}
+			c, size := utf8.DecodeRuneInString(text[position:])
+			if t.acceptsBefore(s, c) {
+				end, runeEnd = position, runePosition
+			}
+			if s = t.step(s, c); s == -1 {
+				break
+			}
+			position += size
+			runePosition++
+		}
+		if end >= 0 {
+			if r.program.lazy {
+				// the match chosen by priority ends at or before the longest one
+				after := rune(-1)
+				if end < len(text) {
+					after, _ = utf8.DecodeRuneInString(text[end:])
+				}
+				// sizes are those of the runes decoded, an invalid byte being a rune of size 1
+				var runes []rune
+				var sizes []int
+				for i := start; i < end; {
+					c, size := utf8.DecodeRuneInString(text[i:])
+					runes, sizes = append(runes, c), append(sizes, size)
+					i += size
+				}
+				if n := r.program.firstEnd(runes, previous, after); n >= 0 {
+					runeEnd, end = runeStart+n, start
+					for _, size := range sizes[:n] {
+						end += size
+					}
+				} else {
+					end = -1
+				}
+			}
+			if end >= 0 {
+				return &Location{text[start:end], start, end, runeStart, runeEnd}
+			}
+		}
+		if start >= len(text) {
+			return nil
+		}
+		c, size := utf8.DecodeRuneInString(text[start:])
+		start += size
+		runeStart++
+		previous = c
+	}
+}
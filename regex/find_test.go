@@ -0,0 +1,120 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	r := NewRegex("\\d+")
+	if f := r.Find("abc 123 45"); f != "123" {
+		t.Errorf("'\\d+' found %q in 'abc 123 45'", f)
+	}
+	if f := r.Find("abc"); f != "" {
+		t.Errorf("'\\d+' found %q in 'abc'", f)
+	}
+	if loc := r.FindIndex("abc"); loc != nil {
+		t.Error("'\\d+' found a match in 'abc'", loc)
+	}
+}
+
+func TestFindLeftmostLongest(t *testing.T) {
+	r := NewRegex("a|ab|abc")
+	loc := r.FindIndex("xxabcd")
+	if loc == nil || loc.Text != "abc" || loc.Start != 2 || loc.End != 5 {
+		t.Error("'a|ab|abc' should find 'abc' at 2 in 'xxabcd', found", loc)
+	}
+
+	r = NewRegex("b+|ab")
+	loc = r.FindIndex("abbb")
+	if loc == nil || loc.Text != "ab" || loc.Start != 0 {
+		t.Error("'b+|ab' should find 'ab' at 0 in 'abbb', found", loc)
+	}
+}
+
+func TestFindIndexOffsets(t *testing.T) {
+	r := NewRegex("[日本語]+")
+	loc := r.FindIndex("ab日本語cd")
+	expected := Location{"日本語", 2, 11, 2, 5}
+	if loc == nil || *loc != expected {
+		t.Error("expected", expected, "found", loc)
+	}
+}
+
+func TestFindEmpty(t *testing.T) {
+	r := NewRegex("a*")
+	loc := r.FindIndex("bbb")
+	if loc == nil || loc.Start != 0 || loc.End != 0 {
+		t.Error("'a*' should find an empty match at 0 in 'bbb', found", loc)
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	r := NewRegex("[a-z]+")
+	found := r.FindAll("let x1 = yy + 日z;", -1)
+	if !slices.Equal(found, []string{"let", "x", "yy", "z"}) {
+		t.Error("'[a-z]+' found", found)
+	}
+	found = r.FindAll("let x1 = yy + 日z;", 2)
+	if !slices.Equal(found, []string{"let", "x"}) {
+		t.Error("'[a-z]+' found", found, "with a limit of 2")
+	}
+}
+
+func TestFindAllIndex(t *testing.T) {
+	r := NewRegex("a*")
+	var locs [][2]int
+	for _, loc := range r.FindAllIndex("baaab", -1) {
+		locs = append(locs, [2]int{loc.Start, loc.End})
+	}
+	// same as the standard regexp package
+	expected := [][2]int{{0, 0}, {1, 4}, {5, 5}}
+	if !slices.Equal(locs, expected) {
+		t.Error("'a*' expected", expected, "found", locs)
+	}
+
+	r = NewRegex("\\w+")
+	var runes [][2]int
+	for _, loc := range r.FindAllIndex("é ab 日 cd", -1) {
+		runes = append(runes, [2]int{loc.RuneStart, loc.RuneEnd})
+	}
+	expected = [][2]int{{2, 4}, {7, 9}}
+	if !slices.Equal(runes, expected) {
+		t.Error("'\\w+' expected rune offsets", expected, "found", runes)
+	}
+}
+
+func TestFindReader(t *testing.T) {
+	r := NewRegex("ERROR: [^\\n]*")
+	log := "INFO: started\nWARN: slow 日本\nERROR: disk full\nINFO: done"
+	loc, err := r.FindReader(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := r.FindIndex(log)
+	if loc == nil || *loc != *expected {
+		t.Error("expected", expected, "found", loc)
+	}
+
+	loc, err = r.FindReader(strings.NewReader("INFO: done"))
+	if err != nil || loc != nil {
+		t.Error("found", loc, err, "in a text without errors")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) ReadRune() (rune, int, error) {
+	return 0, 0, errors.New("read failed")
+}
+
+func TestFindReaderError(t *testing.T) {
+	_, err := NewRegex("a").FindReader(failingReader{})
+	if err == nil {
+		t.Error("reader error not returned")
+	}
+}
@@ -0,0 +1,293 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GoOptions are the options of the Go source generated for a compiled regular
+// expression or a set of regular expressions.
+type GoOptions struct {
+	// Package is the name of the package of the generated file.
+	Package string
+
+	// Name prefixes the identifiers generated: exported functions and variables start
+	// with it and unexported ones with it in lower case.
+	Name string
+
+	// Names are the names of the regular expressions of a set, by index, generated as
+	// the variable <Name>Names; the patterns of the regular expressions if empty.
+	Names []string
+}
+
+// GenerateGo writes a Go source file implementing the regular expression as a state
+// machine over its DFA, with no dependency on this module, declaring:
+//
+//	// <Name> returns true if the whole input matches the regular expression.
+//	func <Name>(input string) bool
+//
+//	// <Name>Prefix returns the length in bytes of the longest prefix of the input
+//	// matching the regular expression, or -1 if none does.
+//	func <Name>Prefix(input string) int
+//
+// Lazy quantifiers do not change the strings matched and are ignored.
+func (r *CompiledRegex) GenerateGo(w io.Writer, options GoOptions) error {
+	g, err := newGenerator(options, r.table)
+	if err != nil {
+		return err
+	}
+	pattern := strconv.Quote(r.source)
+	g.printf("// %s returns true if the whole input matches the regular expression %s.\n", g.name, pattern)
+	g.printf("func %s(input string) bool {\n", g.name)
+	g.printf("state := %d\n", r.table.starts[textStart])
+	g.printf("for _, c := range input {\n")
+	g.printf("if state = %sStep(state, c); state == -1 {\nreturn false\n}\n}\n", g.unexported)
+	g.printf("return %sFinal(state, -1)\n}\n\n", g.unexported)
+
+	g.printf("// %sPrefix returns the length in bytes of the longest prefix of the input matching\n", g.name)
+	g.printf("// the regular expression %s, or -1 if none does.\n", pattern)
+	g.printf("func %sPrefix(input string) int {\n", g.name)
+	g.printf("state, end := %d, -1\n", r.table.starts[textStart])
+	g.printf("for i, c := range input {\n")
+	g.printf("if %sFinal(state, c) {\nend = i\n}\n", g.unexported)
+	g.printf("if state = %sStep(state, c); state == -1 {\nreturn end\n}\n}\n", g.unexported)
+	g.printf("if %sFinal(state, -1) {\nreturn len(input)\n}\n", g.unexported)
+	g.printf("return end\n}\n\n")
+
+	g.accept("Final", "bool", "false", "returns true if state is final when followed by next, or by the\n// end of the text if next is negative.",
+		func(s int, next lookahead) string {
+			return strconv.FormatBool(acceptsAt(r.table, s, next))
+		})
+	g.step()
+	return g.write(w)
+}
+
+// GenerateGo writes a Go source file implementing the set of regular expressions as
+// a state machine over its DFA, with no dependency on this module, for lexing with
+// the longest match at each position, declaring:
+//
+//	// <Name>Names are the names of the regular expressions by index.
+//	var <Name>Names = [...]string{...}
+//
+//	// <Name>Next returns the index of the highest priority regular expression matching
+//	// the longest non-empty prefix of the input, which follows the character previous
+//	// (negative at the start of the text), with the length in bytes of the prefix, or
+//	// -1 and 0 if none matches.
+//	func <Name>Next(input string, previous rune) (int, int)
+//
+// Empty matches are ignored, as by the lexer, so that calling <Name>Next on the rest of
+// the input always makes progress.
+//
+// It fails if a regular expression has lazy quantifiers, as their earliest acceptable
+// end is not a property of the states of the DFA.
+func (s *RegexSet) GenerateGo(w io.Writer, options GoOptions) error {
+	for _, r := range s.Regexes {
+		if r.program.lazy {
+			return fmt.Errorf("regex: cannot generate %s with lazy quantifiers", strconv.Quote(r.source))
+		}
+	}
+	if options.Names != nil && len(options.Names) != len(s.Regexes) {
+		return fmt.Errorf("regex: %d names for %d regular expressions", len(options.Names), len(s.Regexes))
+	}
+	g, err := newGenerator(options, s.table)
+	if err != nil {
+		return err
+	}
+	g.printf("// %sNames are the names of the regular expressions by index, in priority order.\n", g.name)
+	g.printf("var %sNames = [...]string{\n", g.name)
+	for i, r := range s.Regexes {
+		if options.Names != nil {
+			g.printf("%s, // %s\n", strconv.Quote(options.Names[i]), strconv.Quote(r.source))
+		} else {
+			g.printf("%s,\n", strconv.Quote(r.source))
+		}
+	}
+	g.printf("}\n\n")
+
+	g.printf("// %sNext returns the index in %sNames of the highest priority regular expression\n", g.name, g.name)
+	g.printf("// matching the longest non-empty prefix of the input, which follows the character\n")
+	g.printf("// previous (negative at the start of the text), with the length in bytes of the\n")
+	g.printf("// prefix, or -1 and 0 if none matches.\n")
+	g.printf("func %sNext(input string, previous rune) (int, int) {\n", g.name)
+	starts := s.table.starts
+	if starts[textStart] == starts[afterOther] && starts[textStart] == starts[afterWord] {
+		g.printf("state := %d\n", starts[textStart])
+	} else {
+		g.printf("state := %d\n", starts[textStart])
+		g.printf("if previous >= 0 {\nif %sWord(previous) {\nstate = %d\n} else {\nstate = %d\n}\n}\n",
+			g.unexported, starts[afterWord], starts[afterOther])
+		g.word = true
+	}
+	g.printf("match, end := -1, 0\n")
+	g.printf("for i, c := range input {\n")
+	g.printf("if m := %sAccept(state, c); m != -1 && i > 0 {\nmatch, end = m, i\n}\n", g.unexported)
+	g.printf("if state = %sStep(state, c); state == -1 {\nreturn match, end\n}\n}\n", g.unexported)
+	g.printf("if m := %sAccept(state, -1); m != -1 && len(input) > 0 {\nreturn m, len(input)\n}\n", g.unexported)
+	g.printf("return match, end\n}\n\n")
+
+	g.accept("Accept", "int", "-1", "returns the index of the highest priority regular expression matched\n// in state when followed by next, or by the end of the text if next is negative, or -1.",
+		func(state int, next lookahead) string {
+			if m := s.table.matches[state]; m != nil && len(m.full[next]) > 0 {
+				return strconv.Itoa(m.full[next][0])
+			}
+			return "-1"
+		})
+	g.step()
+	return g.write(w)
+}
+
+// acceptsAt returns true if state s of the table is final before the lookahead.
+func acceptsAt(t *table, s int, next lookahead) bool {
+	if t.accepts == nil || next == beforeEnd {
+		return t.final[s]
+	}
+	return t.accepts[s]&(1<<next) != 0
+}
+
+// generator writes the Go source of a state machine over the table of a DFA.
+type generator struct {
+	options GoOptions
+	table   *table
+
+	// name and unexported prefix the exported and unexported identifiers generated.
+	name, unexported string
+
+	// word is true if the function testing for word characters is used.
+	word bool
+
+	body bytes.Buffer
+}
+
+func newGenerator(options GoOptions, t *table) (*generator, error) {
+	if !token.IsIdentifier(options.Package) || !token.IsIdentifier(options.Name) {
+		return nil, fmt.Errorf("regex: invalid package %q or name %q", options.Package, options.Name)
+	}
+	first, size := utf8.DecodeRuneInString(options.Name)
+	unexported := string(unicode.ToLower(first)) + options.Name[size:]
+	return &generator{options: options, table: t, name: options.Name, unexported: unexported}, nil
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.body, format, args...)
+}
+
+// accept writes the function <name><suffix>(state int, next rune) returning the value
+// for each state and lookahead, omitting the states returning none and grouping the
+// states returning the same values.
+func (g *generator) accept(suffix string, result string, none string, doc string, value func(int, lookahead) string) {
+	g.printf("// %s%s %s\n", g.unexported, suffix, doc)
+	g.printf("func %s%s(state int, next rune) %s {\n", g.unexported, suffix, result)
+	g.printf("switch state {\n")
+	var order [][3]string
+	states := map[[3]string][]string{}
+	for s := range g.table.states {
+		var values [3]string
+		for next := range values {
+			values[next] = value(s, lookahead(next))
+		}
+		if values == [3]string{none, none, none} {
+			continue
+		}
+		if _, ok := states[values]; !ok {
+			order = append(order, values)
+		}
+		states[values] = append(states[values], strconv.Itoa(s))
+	}
+	for _, values := range order {
+		g.printf("case %s:\n", strings.Join(states[values], ", "))
+		if values[0] == values[1] && values[0] == values[2] {
+			g.printf("return %s\n", values[0])
+			continue
+		}
+		g.word = true
+		g.printf("switch {\ncase next < 0:\nreturn %s\n", values[beforeEnd])
+		g.printf("case %sWord(next):\nreturn %s\n", g.unexported, values[beforeWord])
+		g.printf("default:\nreturn %s\n}\n", values[beforeOther])
+	}
+	g.printf("}\nreturn %s\n}\n\n", none)
+}
+
+// step writes the function <name>Step(state int, c rune) int returning the state
+// reached from state on the character, or -1 if none, and the test for word characters
+// if it is used.
+func (g *generator) step() {
+	t := g.table
+	classes := t.classSpans()
+	g.printf("// %sStep returns the state reached from state on the character c, or -1 if none.\n", g.unexported)
+	g.printf("func %sStep(state int, c rune) int {\n", g.unexported)
+	g.printf("switch state {\n")
+	for s := range t.states {
+		// the characters leading to each state reached from s
+		chars := map[int32]spanSet{}
+		for class, spans := range classes {
+			if next := t.next[s*t.classes+class]; next != -1 {
+				chars[next] = append(chars[next], spans...)
+			}
+		}
+		if len(chars) == 0 {
+			continue
+		}
+		targets := make([]int32, 0, len(chars))
+		for next, spans := range chars {
+			chars[next] = spans.compact()
+			targets = append(targets, next)
+		}
+		slices.SortFunc(targets, func(a, b int32) int {
+			return int(chars[a][0].from) - int(chars[b][0].from)
+		})
+		g.printf("case %d:\nswitch {\n", s)
+		for _, next := range targets {
+			g.printf("case ")
+			for i, sp := range chars[next] {
+				if i > 0 {
+					g.printf(", ")
+				}
+				if sp.from == sp.to {
+					g.printf("c == %s", quoteRune(sp.from))
+				} else {
+					g.printf("c >= %s && c <= %s", quoteRune(sp.from), quoteRune(sp.to))
+				}
+			}
+			g.printf(":\nreturn %d\n", next)
+		}
+		g.printf("}\n")
+	}
+	g.printf("}\nreturn -1\n}\n")
+	if g.word {
+		g.printf("\n// %sWord returns true if c is a word character for the assertions \\b and \\B.\n", g.unexported)
+		g.printf("func %sWord(c rune) bool {\n", g.unexported)
+		g.printf("return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c == '_' || c >= 'a' && c <= 'z'\n}\n")
+	}
+}
+
+// write formats the source generated and writes it after the header of the file.
+func (g *generator) write(w io.Writer) error {
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by prefix_regex_matcher. DO NOT EDIT.\n\npackage %s\n\n", g.options.Package)
+	src.Write(g.body.Bytes())
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("regex: generated invalid Go source: %w", err)
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+// quoteRune returns the Go literal of the character, as a number if it is not a
+// valid character.
+func quoteRune(c rune) string {
+	if utf8.ValidRune(c) {
+		return strconv.QuoteRune(c)
+	}
+	return fmt.Sprintf("0x%x", c)
+}
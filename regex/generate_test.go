@@ -0,0 +1,133 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// longestPrefix returns the length in bytes of the longest prefix of the input matched
+// by the regular expression, or -1.
+func longestPrefix(r *CompiledRegex, input string) int {
+	m, end := r.Matcher(), -1
+	for i, c := range input {
+		if m.FullMatchBefore(c) {
+			end = i
+		}
+		if m.MatchNext(c) == NoMatch {
+			return end
+		}
+	}
+	if m.FullMatchBefore(-1) {
+		return len(input)
+	}
+	return end
+}
+
+// nextInSet returns the highest priority regular expression of the set matching the
+// longest non-empty prefix of the input after previous, and the length of the prefix.
+func nextInSet(s *RegexSet, input string, previous rune) (int, int) {
+	m, match, end := s.Matcher(), -1, 0
+	m.ResetAfter(previous)
+	for i, c := range input {
+		if full := m.FullBefore(c); len(full) > 0 && i > 0 {
+			match, end = full[0], i
+		}
+		if m.MatchNext(c) == NoMatch {
+			return match, end
+		}
+	}
+	if full := m.FullBefore(-1); len(full) > 0 && len(input) > 0 {
+		return full[0], len(input)
+	}
+	return match, end
+}
+
+func TestGenerateGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated code")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	regexes := []string{`[0-9]+(\.[0-9]+)?\b`, `\bcat|dog$`, `[\p{Greek}--[α]]+x?`, `(ab|a)*?c`, `[^a]*`}
+	inputs := []string{"", "12", "12.5", "12.5x", "12.", "cat", "dog", "dogs", "catalog", "βγ", "αβ", "βx", "ababc", "aac", "bbb", "bé\U0001f600a"}
+	set := MustCompileSet(`if\b`, `[a-z]+`, `[0-9]+`, `[0-9]+\.[0-9]+`, `\s+`, `\bx`, `.`, `\s*`)
+	previous := []rune{-1, ' ', 'a'}
+
+	dir := t.TempDir()
+	write := func(name string, content []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", []byte("module generated\n\ngo 1.23\n"))
+
+	var main, expected bytes.Buffer
+	main.WriteString("package main\n\nimport \"fmt\"\n\nfunc main() {\n")
+	for i, p := range regexes {
+		r := MustCompile(p)
+		var src bytes.Buffer
+		if err := r.GenerateGo(&src, GoOptions{Package: "main", Name: fmt.Sprintf("R%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+		write(fmt.Sprintf("r%d.go", i), src.Bytes())
+		for _, input := range inputs {
+			fmt.Fprintf(&main, "fmt.Println(R%d(%q), R%dPrefix(%q))\n", i, input, i, input)
+			fmt.Fprintln(&expected, r.Match(input), longestPrefix(r, input))
+		}
+	}
+	var src bytes.Buffer
+	if err := set.GenerateGo(&src, GoOptions{Package: "main", Name: "Lex"}); err != nil {
+		t.Fatal(err)
+	}
+	write("lex.go", src.Bytes())
+	for _, input := range append(inputs, "if x", "iffy", "if", "x1.5 ") {
+		for _, p := range previous {
+			fmt.Fprintf(&main, "fmt.Println(LexNext(%q, %d))\n", input, p)
+			m, n := nextInSet(set, input, p)
+			fmt.Fprintln(&expected, m, n)
+		}
+	}
+	main.WriteString("}\n")
+	write("main.go", main.Bytes())
+
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	got, want := strings.Split(string(out), "\n"), strings.Split(expected.String(), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("expected %d lines, got %d: %s", len(want), len(got), out)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d: expected %q, got %q", i+1, want[i], got[i])
+		}
+	}
+}
+
+func TestGenerateGoErrors(t *testing.T) {
+	var out bytes.Buffer
+	if err := MustCompile("a").GenerateGo(&out, GoOptions{Package: "p", Name: "1a"}); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+	if err := MustCompileSet("a", "/\\*.*?\\*/").GenerateGo(&out, GoOptions{Package: "p", Name: "L"}); err == nil {
+		t.Error("expected an error for lazy quantifiers in a set")
+	}
+	if err := MustCompileSet("a").GenerateGo(&out, GoOptions{Package: "p", Name: "L", Names: []string{"A", "B"}}); err == nil {
+		t.Error("expected an error for the number of names")
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output on errors, got %q", out.String())
+	}
+}
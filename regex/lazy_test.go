@@ -0,0 +1,129 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLazyMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"a*?", []string{"", "aaa"}, []string{"b"}},
+		{"a+?b", []string{"ab", "aaab"}, []string{"b", "aba"}},
+		{"a??b", []string{"b", "ab"}, []string{"aab"}},
+		{"a{2,3}?", []string{"aa", "aaa"}, []string{"a", "aaaa"}},
+		{"a{2,}?", []string{"aa", "aaaaa"}, []string{"a"}},
+		{"<!--.*?-->", []string{"<!-- x -->", "<!-- x --> -->"}, []string{"<!-- x"}},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.pattern)
+		if r.Regex.Pattern() != test.pattern {
+			t.Errorf("%q printed as %q", test.pattern, r.Regex.Pattern())
+		}
+		for _, s := range test.matches {
+			if !r.Match(s) {
+				t.Errorf("%q did not match %q", test.pattern, s)
+			}
+		}
+		for _, s := range test.fails {
+			if r.Match(s) {
+				t.Errorf("%q matched %q", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestFindLazy(t *testing.T) {
+	texts := []string{
+		"<!-- one --> x <!-- two -->",
+		"aaabbb ab b",
+		"x = 'a' + 'b'",
+		"",
+	}
+	for _, pattern := range []string{
+		"<!--.*?-->", "a+?", "a*?", "a+?b+", "a??b", "a{1,3}?", "a{2,}?b", "'.*?'", "(a|ab)+?b", "\\b\\w+?\\b", "a+?\\b",
+	} {
+		expected := regexp.MustCompile(pattern)
+		r := MustCompile(pattern)
+		for _, text := range texts {
+			var want, got [][2]int
+			for _, loc := range expected.FindAllStringIndex(text, -1) {
+				want = append(want, [2]int{loc[0], loc[1]})
+			}
+			for _, loc := range r.FindAllIndex(text, -1) {
+				got = append(got, [2]int{loc.Start, loc.End})
+			}
+			if !slices.Equal(want, got) {
+				t.Errorf("%q in %q: expected %v, found %v", pattern, text, want, got)
+			}
+			loc, err := r.FindReader(strings.NewReader(text))
+			if err != nil || (loc == nil) != (len(want) == 0) || loc != nil && [2]int{loc.Start, loc.End} != want[0] {
+				t.Errorf("%q in %q: expected %v from a reader, found %v", pattern, text, want, loc)
+			}
+		}
+	}
+}
+
+func TestFindLazyInvalidUTF8(t *testing.T) {
+	// an invalid byte is one rune of one byte, as in regexp
+	text := "\xffab\xffb"
+	for _, pattern := range []string{".+?b", "a.*?b", ".*?b", "(.)+?b"} {
+		loc := regexp.MustCompile(pattern).FindStringIndex(text)
+		found := MustCompile(pattern).FindIndex(text)
+		if found == nil || [2]int{found.Start, found.End} != [2]int{loc[0], loc[1]} {
+			t.Errorf("%q in %q: expected %v, found %v", pattern, text, loc, found)
+		} else if found.RuneEnd-found.RuneStart != utf8.RuneCountInString(text[found.Start:found.End]) {
+			t.Errorf("%q in %q: rune offsets %d-%d disagree with byte offsets %d-%d", pattern, text, found.RuneStart, found.RuneEnd, found.Start, found.End)
+		}
+	}
+}
+
+func TestSubmatchesLazy(t *testing.T) {
+	r := MustCompile("(a+?)(a*)")
+	groups := r.Submatches("aaa")
+	expected := [][2]int{{0, 3}, {0, 1}, {1, 3}}
+	if !equalSpans(spans(groups), expected) {
+		t.Error("expected", expected, "got", spans(groups))
+	}
+}
+
+func TestMatcherLazy(t *testing.T) {
+	m := MustCompile("<!--.*?-->").Matcher()
+	for _, c := range "<!-- a --" {
+		if m.MatchNext(c) != PartialMatch {
+			t.Fatalf("'<!--.*?-->' did not partially match up to %q", c)
+		}
+		if m.Complete() {
+			t.Fatalf("'<!--.*?-->' is complete at %q", c)
+		}
+	}
+	if m.MatchNext('>') != FullMatch || !m.Complete() {
+		t.Error("'<!--.*?-->' is not a complete match of '<!-- a -->'")
+	}
+	if m.MatchNext(' ') != NoMatch {
+		t.Error("'<!--.*?-->' matched past its earliest end")
+	}
+
+	m = MustCompile("a+?").Matcher()
+	if m.MatchNext('a') != FullMatch || !m.Complete() || m.MatchNext('a') != NoMatch {
+		t.Error("'a+?' did not stop at the first 'a'")
+	}
+
+	m = MustCompile("a+").Matcher()
+	if m.MatchNext('a') != FullMatch || m.Complete() {
+		t.Error("'a+' is complete after 'a'")
+	}
+	m = MustCompile("ab").Matcher()
+	m.Match("ab")
+	if !m.Complete() {
+		t.Error("'ab' is not complete after 'ab'")
+	}
+}
@@ -2,7 +2,7 @@
 
 package regex
 
-import "slices"
+import "strings"
 
 type MatchType int
 
@@ -25,16 +25,96 @@ const (
 type Matcher struct {
     LastMatch MatchType
     Matched   string
+
+    // Groups holds the characters matched on transitions labelled with each capture group.
+    //
+    // Deprecated: Groups is wrong for repeated groups and alternatives sharing a prefix,
+    // and has no positions; use Submatches instead.
     Groups    map[int]string
     Compiled  *CompiledRegex
     State     state
+
+    // current is the number of State in the table of the compiled regex.
+    current int32
+
+    // previous is the character before the text matched, negative at the start of the
+    // text, for evaluating assertions.
+    previous rune
+
+    // runner follows the priorities of the paths through the regular expression when
+    // it has lazy quantifiers, and complete is true when the highest priority path
+    // has matched.
+    runner   *runner
+    complete bool
+
+    // matched and groups accumulate the characters of Matched and Groups, which are
+    // views of their content, so that each matched character costs the same.
+    matched strings.Builder
+    groups  map[int]*strings.Builder
 }
 
 func (m *Matcher) Reset() {
+    m.ResetAfter(-1)
+}
+
+// ResetAfter resets the matcher for matching the text following the character
+// previous, instead of the start of the text, so that assertions such as \b at the
+// start of the regular expression take it into account. A negative previous is the
+// same as Reset.
+func (m *Matcher) ResetAfter(previous rune) {
     m.LastMatch = Start
     m.Matched = ""
     m.Groups = make(map[int]string)
-    m.State = m.Compiled.Dfa.start
+    m.previous = previous
+    m.current = m.Compiled.table.startAfter(previous)
+    m.State = m.Compiled.table.states[m.current]
+    m.matched.Reset()
+    m.groups = nil
+    if m.Compiled.program.lazy {
+        m.runner = m.Compiled.program.newRunner(previous)
+        _, m.complete = m.runner.at(-1)
+    }
+}
+
+// FullMatchBefore returns true if the text matched so far is a full match when it is
+// followed by the character next. This differs from the last match being FullMatch,
+// which is relative to the end of the text, only for regular expressions ending with
+// assertions on the next character, such as \b: 'if\b' is a full match of "if"
+// before " " but not before "f".
+func (m *Matcher) FullMatchBefore(next rune) bool {
+    if m.LastMatch == NoMatch {
+        return false
+    }
+    if m.runner != nil {
+        caps, _ := m.runner.at(next)
+        return caps != nil
+    }
+    return m.Compiled.table.acceptsBefore(m.current, next)
+}
+
+// Complete returns true if the text matched so far is a full match which the matcher
+// will not extend. For regular expressions with lazy quantifiers, this is when the
+// earliest acceptable end has been reached, i.e., the highest priority path through
+// the regular expression has matched, after which the matcher does not match any
+// character (e.g., '<!--.*?-->' is complete on the first '-->'). Otherwise it is when
+// no character can follow the text matched.
+func (m *Matcher) Complete() bool {
+    if m.LastMatch != FullMatch && m.LastMatch != Start {
+        return false
+    }
+    if m.runner != nil {
+        return m.complete
+    }
+    t := m.Compiled.table
+    if !t.final[m.current] {
+        return false
+    }
+    for _, next := range t.next[int(m.current)*t.classes : int(m.current+1)*t.classes] {
+        if next != -1 {
+            return false
+        }
+    }
+    return true
 }
 
 func (m *Matcher) Match(input string) bool {
@@ -43,37 +123,73 @@ func (m *Matcher) Match(input string) bool {
             return false
         }
     }
-    return slices.Index(m.Compiled.Dfa.final, m.State) != -1
+    return m.Compiled.table.final[m.current]
 }
 
 func (m *Matcher) MatchNext(r rune) MatchType {
-    trans := m.Compiled.Dfa.Trans[m.State]
-    for c, t := range trans {
-        if c.match(r) {
-            m.State = t
-            if slices.Index(m.Compiled.Dfa.final, t) == -1 {
-                m.LastMatch = PartialMatch
-                m.Matched += string(r)
-            } else {
-                m.LastMatch = FullMatch
-                m.Matched += string(r)
-            }
-            groupSet := set[int]{}
-            groups := c.groups()
-            for g := groups.Front(); g != nil; g = g.Next() {
-                group := g.Value.(int)
-                if group != 0 {
-                    groupSet[group] = true
-                }
-                _, ok := m.Groups[g.Value.(int)]
-                if !ok {
-                    m.Groups[group] = ""
-                }
-                m.Groups[group] += string(r)
-            }
+    if m.LastMatch == NoMatch {
+        return NoMatch
+    }
+    if m.runner != nil {
+        // matching stops at the earliest acceptable end for lazy quantifiers
+        if _, earliest := m.runner.at(r); earliest || !m.runner.advance(r) {
+            m.LastMatch = NoMatch
             return m.LastMatch
         }
     }
-    m.LastMatch = NoMatch
+    t := m.Compiled.table
+    next, groups := t.stepGroups(m.current, r)
+    if next == -1 {
+        m.LastMatch = NoMatch
+        return m.LastMatch
+    }
+    m.current = next
+    m.State = t.states[next]
+    if m.runner != nil {
+        var caps []int
+        caps, m.complete = m.runner.at(-1)
+        if caps != nil {
+            m.LastMatch = FullMatch
+        } else {
+            m.LastMatch = PartialMatch
+        }
+    } else if t.final[next] {
+        m.LastMatch = FullMatch
+    } else {
+        m.LastMatch = PartialMatch
+    }
+    m.matched.WriteRune(r)
+    m.Matched = m.matched.String()
+
+    for _, group := range groups {
+        if m.groups == nil {
+            m.groups = make(map[int]*strings.Builder)
+        }
+        b, ok := m.groups[group]
+        if !ok {
+            b = &strings.Builder{}
+            m.groups[group] = b
+        }
+        b.WriteRune(r)
+        m.Groups[group] = b.String()
+    }
     return m.LastMatch
 }
+
+// Submatches returns the locations, relative to the start of Matched, of the capture
+// groups of the regular expression in the text matched so far, indexed by group number
+// with group 0 being the whole of Matched. It returns nil if the last match was not a
+// full match. A group which did not take part in the match is nil; a group matched
+// repeatedly reports its last iteration.
+func (m *Matcher) Submatches() []*Location {
+    if m.LastMatch != FullMatch && !(m.LastMatch == Start && m.Compiled.table.final[m.current]) {
+        return nil
+    }
+    return locations(m.Compiled.program.submatches([]rune(m.Matched), m.previous, -1), m.Matched, 0, 0)
+}
+
+// NamedSubmatches is the same as Submatches but returns the locations of the named
+// capture groups by name. A named group which did not take part in the match is nil.
+func (m *Matcher) NamedSubmatches() map[string]*Location {
+    return m.Compiled.named(m.Submatches())
+}
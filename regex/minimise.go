@@ -0,0 +1,385 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// minimise returns the minimal DFA equivalent to this one, using Hopcroft's partition
+// refinement algorithm. The alphabet is made of the classes of characters which lead
+// to the same states from every state of the DFA. States are initially partitioned on
+// whether they are final and on the capture-group labels of their transitions, so that
+// minimisation never merges states whose transitions contribute to different groups.
+// States which cannot reach a final state are removed.
+func (auto *automata) minimise() *automata {
+	states := auto.live()
+	index := make(map[state]int, len(states))
+	for i, s := range states {
+		index[s] = i
+	}
+
+	// the dead state is added to make the automaton complete
+	dead := len(states)
+	count := dead + 1
+
+	alphabet := auto.alphabet(states, index)
+
+	// delta[s][sym] is the target of state s on symbol sym (dead if none) and
+	// output[s][sym] the capture-group label of that transition
+	delta := make([][]int, count)
+	output := make([][]string, count)
+	for s := 0; s < count; s++ {
+		delta[s] = make([]int, len(alphabet))
+		output[s] = make([]string, len(alphabet))
+		for sym, a := range alphabet {
+			delta[s][sym] = dead
+			if s != dead {
+				if c := a.transition(auto.Trans[states[s]]); c != nil {
+					if t, ok := index[auto.Trans[states[s]][c]]; ok {
+						delta[s][sym] = t
+						output[s][sym] = label(c.groups())
+					}
+				}
+			}
+		}
+	}
+
+	// inverse[sym][target] are the states reaching target on sym
+	inverse := make([][][]int, len(alphabet))
+	for sym := range inverse {
+		inverse[sym] = make([][]int, count)
+	}
+	for s := 0; s < count; s++ {
+		for sym, t := range delta[s] {
+			inverse[sym][t] = append(inverse[sym][t], s)
+		}
+	}
+
+	// initial partition on finality and transition outputs
+	block := make([]int, count)
+	var partition [][]int
+	pos := make([]int, count) // position of each state in its block
+	initial := map[string]int{}
+	for s := 0; s < count; s++ {
+		k := "n"
+		if s == dead {
+			k = "d"
+		} else if slices.Index(auto.final, states[s]) != -1 {
+			k = "f"
+		}
+		if auto.accepts != nil && s != dead {
+			k += string(rune('0' + auto.accepts[states[s]]))
+		}
+		if auto.matches != nil && s != dead {
+			k += auto.matches[states[s]].key()
+		}
+		for _, o := range output[s] {
+			k += ":" + o
+		}
+		b, ok := initial[k]
+		if !ok {
+			b = len(partition)
+			initial[k] = b
+			partition = append(partition, nil)
+		}
+		block[s] = b
+		pos[s] = len(partition[b])
+		partition[b] = append(partition[b], s)
+	}
+	var work []int
+	inWork := make([]bool, len(partition))
+	for b := range partition {
+		work = append(work, b)
+		inWork[b] = true
+	}
+
+	for len(work) > 0 {
+		splitter := work[len(work)-1]
+		work = work[:len(work)-1]
+		inWork[splitter] = false
+		members := slices.Clone(partition[splitter])
+
+		for sym := range inverse {
+			// states reaching the splitter on sym, grouped by their current block
+			touched := map[int][]int{}
+			var order []int
+			for _, t := range members {
+				for _, s := range inverse[sym][t] {
+					if _, ok := touched[block[s]]; !ok {
+						order = append(order, block[s])
+					}
+					touched[block[s]] = append(touched[block[s]], s)
+				}
+			}
+			for _, b := range order {
+				in := touched[b]
+				if len(in) == len(partition[b]) {
+					continue
+				}
+				// the states split off are swapped with the last ones of the block,
+				// in time proportional to their number instead of the size of the block
+				out := partition[b]
+				n := len(partition)
+				for i, s := range in {
+					last := out[len(out)-1]
+					out[pos[s]], pos[last] = last, pos[s]
+					out = out[:len(out)-1]
+					block[s], pos[s] = n, i
+				}
+				partition[b] = out
+				partition = append(partition, in)
+				inWork = append(inWork, false)
+				if inWork[b] || len(in) <= len(out) {
+					work = append(work, n)
+					inWork[n] = true
+				} else {
+					work = append(work, b)
+					inWork[b] = true
+				}
+			}
+		}
+	}
+
+	// build the minimal automaton, dropping the block of the dead state
+	minimal := automata{
+		Trans: make(transitions),
+		final: []state{},
+	}
+	if auto.accepts != nil {
+		minimal.accepts = map[state]uint8{}
+	}
+	if auto.matches != nil {
+		minimal.matches = map[state]*setMatch{}
+	}
+	deadBlock := block[dead]
+	newStates := make([]state, len(partition))
+	for b := range partition {
+		if b != deadBlock {
+			newStates[b] = newState()
+		}
+	}
+
+	// start states which cannot reach a final state are replaced by a non-final state
+	// without transitions
+	for _, s := range auto.startStates() {
+		if i, ok := index[s]; ok {
+			minimal.starts = append(minimal.starts, newStates[block[i]])
+		} else {
+			minimal.starts = append(minimal.starts, newState())
+		}
+	}
+	minimal.start = minimal.starts[0]
+	for b, members := range partition {
+		if b == deadBlock {
+			continue
+		}
+		rep := members[0]
+		if slices.Index(auto.final, states[rep]) != -1 {
+			minimal.final = append(minimal.final, newStates[b])
+		}
+		if auto.accepts != nil {
+			minimal.accepts[newStates[b]] = auto.accepts[states[rep]]
+		}
+		if auto.matches != nil {
+			minimal.matches[newStates[b]] = auto.matches[states[rep]]
+		}
+
+		// merge the symbols going to the same block with the same output into a
+		// single transition
+		merged := map[string]char{}
+		var order []string
+		for sym, t := range delta[rep] {
+			if block[t] == deadBlock {
+				continue
+			}
+			c := alphabet[sym].transition(auto.Trans[states[rep]])
+			k := string(binary.AppendUvarint(nil, uint64(block[t]))) + ":" + output[rep][sym]
+			if alphabet[sym].generator != nil {
+				// characters used only for generation are kept as is
+				k += ":" + c.Pattern()
+			}
+			m, ok := merged[k]
+			if !ok {
+				if alphabet[sym].generator != nil {
+					m = c
+				} else {
+					m = &charClass{}
+					m.setGroups(c.groups())
+				}
+				merged[k] = m
+				order = append(order, k)
+				minimal.addTransitions(newStates[b], map[char]state{m: newStates[block[t]]})
+			}
+			if cc, ok := m.(*charClass); ok {
+				cc.spans = append(cc.spans, alphabet[sym].spans...)
+				for _, src := range c.(*charClass).sources {
+					if slices.Index(cc.sources, src) == -1 {
+						cc.sources = append(cc.sources, src)
+					}
+				}
+			}
+		}
+		for _, k := range order {
+			if cc, ok := merged[k].(*charClass); ok {
+				cc.spans = cc.spans.compact()
+			}
+		}
+	}
+	return &minimal
+}
+
+// symbol is a member of the alphabet of a DFA used for minimisation: either a set of
+// characters taking the same transitions from every state, or a character used only
+// for random generation, identified by its pattern.
+type symbol struct {
+	spans     spanSet
+	generator *string
+}
+
+// transition returns the character labelling the transition on this symbol amongst
+// the transitions of a state, or nil if there is no such transition.
+func (a *symbol) transition(trans map[char]state) char {
+	for c := range trans {
+		if a.generator != nil {
+			if _, ok := c.(*charClass); !ok && c.Pattern() == *a.generator {
+				return c
+			}
+		} else if cc, ok := c.(*charClass); ok && cc.spans.search(a.spans[0].from) {
+			return c
+		}
+	}
+	return nil
+}
+
+// alphabet partitions the characters on the transitions of the states into classes
+// of characters which take the same transitions from every state.
+func (auto *automata) alphabet(states []state, index map[state]int) []*symbol {
+	var sets []spanSet
+	var alphabet []*symbol
+	generators := map[string]bool{}
+	for _, s := range states {
+		for c := range auto.Trans[s] {
+			if cc, ok := c.(*charClass); ok {
+				sets = append(sets, cc.spans)
+			} else if p := c.Pattern(); !generators[p] {
+				generators[p] = true
+				alphabet = append(alphabet, &symbol{generator: &p})
+			}
+		}
+	}
+
+	// split the elementary classes further by the transitions taken from each state
+	// and merge those taking the same transitions everywhere
+	bySignature := map[string]*symbol{}
+	for _, cl := range partition(sets) {
+		a := &symbol{spans: cl.spans}
+		var signature []byte
+		for _, s := range states {
+			t := -1
+			if c := a.transition(auto.Trans[s]); c != nil {
+				if i, ok := index[auto.Trans[s][c]]; ok {
+					t = i
+					signature = append(signature, label(c.groups())...)
+				}
+			}
+			signature = binary.AppendVarint(signature, int64(t))
+		}
+		if existing, ok := bySignature[string(signature)]; ok {
+			existing.spans = append(existing.spans, cl.spans...).compact()
+		} else {
+			bySignature[string(signature)] = a
+			alphabet = append(alphabet, a)
+		}
+	}
+	return alphabet
+}
+
+// live returns the states of the automaton which are reachable from its start state
+// and from which a final state can be reached, in breadth-first order.
+func (auto *automata) live() []state {
+	reachable := auto.states()
+	reverse := map[state][]state{}
+	for _, s := range reachable {
+		for _, t := range auto.Trans[s] {
+			reverse[t] = append(reverse[t], s)
+		}
+	}
+	coReachable := map[state]bool{}
+	var pending []state
+	for _, f := range auto.final {
+		if !coReachable[f] {
+			coReachable[f] = true
+			pending = append(pending, f)
+		}
+	}
+	for s, a := range auto.accepts {
+		if a != 0 && !coReachable[s] {
+			coReachable[s] = true
+			pending = append(pending, s)
+		}
+	}
+	for len(pending) > 0 {
+		s := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, r := range reverse[s] {
+			if !coReachable[r] {
+				coReachable[r] = true
+				pending = append(pending, r)
+			}
+		}
+	}
+	var live []state
+	for _, s := range reachable {
+		if coReachable[s] {
+			live = append(live, s)
+		}
+	}
+	return live
+}
+
+// states returns all the states of the automaton reachable from its start states,
+// in breadth-first order from start.
+func (auto *automata) states() []state {
+	var states []state
+	seen := map[state]bool{}
+	for _, s := range auto.startStates() {
+		if !seen[s] {
+			seen[s] = true
+			states = append(states, s)
+		}
+	}
+	for i := 0; i < len(states); i++ {
+		for _, t := range auto.Trans[states[i]] {
+			if !seen[t] {
+				seen[t] = true
+				states = append(states, t)
+			}
+		}
+	}
+	return states
+}
+
+// startStates returns the start states of the automaton by context, start being first.
+func (auto *automata) startStates() []state {
+	if auto.starts == nil {
+		return []state{auto.start, auto.start, auto.start}
+	}
+	return auto.starts
+}
+
+// StateCount returns the number of states of the automaton reachable from its start states.
+func (auto *automata) StateCount() int {
+	return len(auto.states())
+}
+
+// TransitionCount returns the number of transitions (including empty ones) between
+// the states of the automaton reachable from its start states.
+func (auto *automata) TransitionCount() int {
+	count := 0
+	for _, s := range auto.states() {
+		count += len(auto.Trans[s])
+	}
+	return count
+}
@@ -0,0 +1,78 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMinimiseClassic(t *testing.T) {
+	raw := Config{}.NewRegex("(a|b)*abb")
+	min := NewRegex("(a|b)*abb")
+	if min.Dfa.StateCount() != 4 {
+		t.Error("'(a|b)*abb' minimal DFA should have 4 states, got", min.Dfa.StateCount())
+	}
+	if min.Dfa.StateCount() >= raw.Dfa.StateCount() {
+		t.Error("minimisation did not reduce", raw.Dfa.StateCount(), "states")
+	}
+	if min.Dfa.TransitionCount() != 8 {
+		t.Error("'(a|b)*abb' minimal DFA should have 8 transitions, got", min.Dfa.TransitionCount())
+	}
+}
+
+func TestMinimiseKeywords(t *testing.T) {
+	pattern := "if|in|int|for|foreach|while|do|done|double|return|returns"
+	raw := Config{}.NewRegex(pattern)
+	min := NewRegex(pattern)
+	if min.Dfa.StateCount() >= raw.Dfa.StateCount() {
+		t.Error("minimisation did not reduce", raw.Dfa.StateCount(), "states")
+	}
+	for _, s := range []string{"if", "in", "int", "for", "foreach", "done", "double", "returns"} {
+		if !min.Match(s) {
+			t.Errorf("minimised %q did not match %q", pattern, s)
+		}
+	}
+	for _, s := range []string{"", "i", "inte", "fore", "doubles", "returned"} {
+		if min.Match(s) {
+			t.Errorf("minimised %q matched %q", pattern, s)
+		}
+	}
+}
+
+func TestMinimiseEquivalent(t *testing.T) {
+	for _, pattern := range []string{
+		"(a|b)*abb",
+		"(ab|ac){2,4}",
+		"a*(b|c)+a?",
+		"(a|ab)(c|bcd)(d*)",
+		"x(ab(vw(cd)|(ef))?)|(a(fc)*\\*[a-z]+)",
+	} {
+		raw := Config{}.NewRegex(pattern)
+		min := NewRegex(pattern)
+		for i := 0; i < 500; i++ {
+			n := rand.Intn(8)
+			s := make([]rune, n)
+			for j := range s {
+				s[j] = rune("abcdefvwx*"[rand.Intn(10)])
+			}
+			if raw.Match(string(s)) != min.Match(string(s)) {
+				t.Errorf("%q: minimised and raw DFAs disagree on %q", pattern, string(s))
+			}
+		}
+	}
+}
+
+func TestMinimiseGroups(t *testing.T) {
+	r := NewRegex("(aab)|(aac)")
+	m := r.Matcher()
+	m.Match("aab")
+	if m.Groups[1] != "aab" || m.Groups[0] != "aab" {
+		t.Error("'(aab)|(aac)' groups of minimised DFA are wrong for 'aab'", m.Groups)
+	}
+	m.Reset()
+	m.Match("aac")
+	if m.Groups[2] != "aac" || m.Groups[0] != "aac" {
+		t.Error("'(aab)|(aac)' groups of minimised DFA are wrong for 'aac'", m.Groups)
+	}
+}
@@ -0,0 +1,336 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"unicode/utf8"
+)
+
+// Submatches are extracted by running a Pike VM over a program compiled from the
+// regular expression. The threads of the VM are kept in priority order, alternatives
+// on the left and greedy repetitions having priority, so that the groups reported
+// are those of the highest priority path (as in Perl) amongst the paths matching
+// the longest text found by the DFA. A group matching more than once, such as
+// a group under repetition, reports its last iteration.
+
+type (
+	opcode uint8
+
+	// inst is an instruction of a program run by the Pike VM.
+	inst struct {
+		op opcode
+
+		// chars are the characters matched by an opChar instruction, as a compact span set.
+		chars spanSet
+
+		// out is the next instruction; out1 is the alternative, of lower priority, of
+		// an opSplit instruction.
+		out, out1 int
+
+		// slot is the capture slot in which an opSave instruction saves the position.
+		slot int
+
+		// assert is the kind of assertion checked by an opAssert instruction.
+		assert assertKind
+	}
+
+	// program is a sequence of instructions recognising a regular expression and
+	// saving the positions of its capture groups.
+	program struct {
+		insts []inst
+		start int
+
+		// groups is the number of capture groups, including group 0 for the whole match.
+		groups int
+
+		// lazy is true if the program has lazy quantifiers, in which case the end of
+		// the matches found is chosen by priority instead of length.
+		lazy bool
+	}
+)
+
+const (
+	// opChar matches a character against chars and continues at out.
+	opChar opcode = iota
+
+	// opSplit continues at both out and out1, preferring out.
+	opSplit
+
+	// opSave saves the current position in a capture slot and continues at out.
+	opSave
+
+	// opMatch accepts the input.
+	opMatch
+
+	// opAssert continues at out if its assertion holds at the current position.
+	opAssert
+)
+
+// newProgram compiles the regular expression with the number of capture groups
+// (excluding group 0) into a program for the Pike VM.
+func newProgram(r Regex, groups int) *program {
+	p := &program{groups: groups + 1}
+	next := p.add(inst{op: opMatch})
+	next = p.add(inst{op: opSave, out: next, slot: 1})
+	next = r.emit(p, next)
+	p.start = p.add(inst{op: opSave, out: next, slot: 0})
+	return p
+}
+
+// add appends an instruction and returns its index.
+func (p *program) add(i inst) int {
+	p.insts = append(p.insts, i)
+	return len(p.insts) - 1
+}
+
+// split appends an opSplit instruction continuing at out in priority and at out1.
+func (p *program) split(out int, out1 int) int {
+	return p.add(inst{op: opSplit, out: out, out1: out1})
+}
+
+// choose appends an opSplit instruction continuing at body and at next, preferring
+// body unless lazy. A body of -1 is set later with setBody.
+func (p *program) choose(body int, next int, lazy bool) int {
+	if lazy {
+		p.lazy = true
+		return p.split(next, body)
+	}
+	return p.split(body, next)
+}
+
+// setBody sets the body of an opSplit instruction appended by choose.
+func (p *program) setBody(split int, body int) {
+	if i := &p.insts[split]; i.out == -1 {
+		i.out = body
+	} else {
+		i.out1 = body
+	}
+}
+
+// emitChar appends the instruction matching a character.
+func emitChar(c char, p *program, next int) int {
+	return p.add(inst{op: opChar, chars: c.matchSet(), out: next})
+}
+
+// thread is a path through the program with the capture positions saved so far.
+type thread struct {
+	pc   int
+	caps []int
+}
+
+// submatches runs the program over the whole input and returns the positions (in
+// runes) of the capture groups for the highest priority path matching all of it,
+// or nil if none does. Each group has two slots, for its start and end, which are
+// -1 if the group did not take part in the match. Before and after are the characters
+// around the input in the text for evaluating assertions, negative at the start and
+// end of the text.
+func (p *program) submatches(input []rune, before rune, after rune) []int {
+	current := make([]thread, 0, len(p.insts))
+	next := make([]thread, 0, len(p.insts))
+	onList := make([]int, len(p.insts))
+	for i := range onList {
+		onList[i] = -1
+	}
+
+	caps := make([]int, 2*p.groups)
+	for i := range caps {
+		caps[i] = -1
+	}
+	// around returns the context and lookahead at a position of the input
+	around := func(pos int) (context, lookahead) {
+		prev, c := before, after
+		if pos > 0 {
+			prev = input[pos-1]
+		}
+		if pos < len(input) {
+			c = input[pos]
+		}
+		return contextAfter(prev), lookaheadBefore(c)
+	}
+	ctx, la := around(0)
+	current = p.addThread(current, onList, 0, p.start, caps, 0, ctx, la)
+	for pos, c := range input {
+		next = next[:0]
+		ctx, la = around(pos + 1)
+		for _, t := range current {
+			i := &p.insts[t.pc]
+			if i.op == opChar && i.chars.search(c) {
+				next = p.addThread(next, onList, pos+1, i.out, t.caps, pos+1, ctx, la)
+			}
+		}
+		current, next = next, current
+	}
+	for _, t := range current {
+		if p.insts[t.pc].op == opMatch {
+			return t.caps
+		}
+	}
+	return nil
+}
+
+// addThread adds the thread at pc to the list, following splits, saves and the
+// assertions holding in the context and lookahead of the position to the instructions
+// consuming a character or matching, in priority order. Instructions already on the
+// list at this step (marked in onList) are not added again as the thread that reached
+// them first has priority.
+func (p *program) addThread(list []thread, onList []int, step int, pc int, caps []int, pos int, ctx context, la lookahead) []thread {
+	if onList[pc] == step {
+		return list
+	}
+	onList[pc] = step
+	i := &p.insts[pc]
+	switch i.op {
+	case opSplit:
+		list = p.addThread(list, onList, step, i.out, caps, pos, ctx, la)
+		list = p.addThread(list, onList, step, i.out1, caps, pos, ctx, la)
+	case opSave:
+		saved := make([]int, len(caps))
+		copy(saved, caps)
+		saved[i.slot] = pos
+		list = p.addThread(list, onList, step, i.out, saved, pos, ctx, la)
+	case opAssert:
+		if i.assert.holds(ctx, la) {
+			list = p.addThread(list, onList, step, i.out, caps, pos, ctx, la)
+		}
+	default:
+		list = append(list, thread{pc, caps})
+	}
+	return list
+}
+
+// locations converts the capture positions in runes of a match in the text into
+// the locations of the groups, offset by the position of the text.
+func locations(caps []int, text string, start int, runeStart int) []*Location {
+	if caps == nil {
+		return nil
+	}
+	// byte offset of each rune position in the text
+	offsets := make([]int, 0, utf8.RuneCountInString(text)+1)
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+
+	groups := make([]*Location, len(caps)/2)
+	for g := range groups {
+		s, e := caps[2*g], caps[2*g+1]
+		if s >= 0 && e >= s {
+			groups[g] = &Location{
+				Text:      text[offsets[s]:offsets[e]],
+				Start:     start + offsets[s],
+				End:       start + offsets[e],
+				RuneStart: runeStart + s,
+				RuneEnd:   runeStart + e,
+			}
+		}
+	}
+	return groups
+}
+
+// runner runs a program over characters supplied one at a time in leftmost-first
+// mode, as in Perl: when a thread matches, the threads of lower priority are cut,
+// while those of higher priority carry on and may find a later match. This is used
+// for regular expressions with lazy quantifiers, for which the end of a match is
+// chosen by priority instead of length.
+type runner struct {
+	p *program
+
+	// pending are the threads at the instructions following the characters consumed
+	// so far, which are followed when the next character is known.
+	pending []thread
+
+	// list are the threads followed from pending for the lookahead of the last call
+	// to at, in priority order.
+	list []thread
+
+	onList   []int
+	step     int
+	pos      int
+	previous rune
+}
+
+// newRunner returns a runner of the program for the text following the character
+// previous, negative at the start of the text.
+func (p *program) newRunner(previous rune) *runner {
+	caps := make([]int, 2*p.groups)
+	for i := range caps {
+		caps[i] = -1
+	}
+	onList := make([]int, len(p.insts))
+	for i := range onList {
+		onList[i] = -1
+	}
+	return &runner{p: p, pending: []thread{{p.start, caps}}, onList: onList, previous: previous}
+}
+
+// at follows the pending threads at the current position, before the character next
+// (negative at the end of the text), and returns the captures of the highest priority
+// thread matching there, or nil if none does. Earliest is true if that thread has
+// the highest priority of all, in which case the match cannot be extended.
+func (r *runner) at(next rune) (caps []int, earliest bool) {
+	r.step++
+	r.list = r.list[:0]
+	ctx, la := contextAfter(r.previous), lookaheadBefore(next)
+	for _, t := range r.pending {
+		from := len(r.list)
+		r.list = r.p.addThread(r.list, r.onList, r.step, t.pc, t.caps, r.pos, ctx, la)
+		for i := from; i < len(r.list); i++ {
+			if r.p.insts[r.list[i].pc].op == opMatch {
+				// threads of lower priority than a match are cut
+				r.list = r.list[:i+1]
+				return r.list[i].caps, i == 0
+			}
+		}
+	}
+	return nil, false
+}
+
+// advance consumes the character c, which must be the one given to the last call
+// of at, and returns false if no thread remains.
+func (r *runner) advance(c rune) bool {
+	r.pending = r.pending[:0]
+	for _, t := range r.list {
+		if i := &r.p.insts[t.pc]; i.op == opChar && i.chars.search(c) {
+			r.pending = append(r.pending, thread{i.out, t.caps})
+		}
+	}
+	r.pos++
+	r.previous = c
+	return len(r.pending) > 0
+}
+
+// firstMatch runs the program in leftmost-first mode from the start of the input,
+// and returns the captures of the match chosen by priority, or nil if there is none.
+// The input only needs to extend to the end of the longest match, as found by the
+// DFA, which the match chosen cannot go past. Before and after are the characters
+// before and after the input, negative at the start and end of the text.
+func (p *program) firstMatch(input []rune, before rune, after rune) []int {
+	r := p.newRunner(before)
+	var best []int
+	for i := 0; ; i++ {
+		next := after
+		if i < len(input) {
+			next = input[i]
+		}
+		caps, earliest := r.at(next)
+		if caps != nil {
+			best = caps
+			if earliest {
+				return best
+			}
+		}
+		if i == len(input) || !r.advance(next) {
+			return best
+		}
+	}
+}
+
+// firstEnd returns the end, in runes, of the match found by firstMatch, or -1 if there
+// is none, which only happens when the program and the DFA which found the longest
+// match disagree, as they may when decoded from corrupt data.
+func (p *program) firstEnd(input []rune, before rune, after rune) int {
+	if caps := p.firstMatch(input, before, after); caps != nil {
+		return caps[1]
+	}
+	return -1
+}
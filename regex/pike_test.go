@@ -0,0 +1,153 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// spans returns the [start, end] byte offsets of each group, or [-1, -1] if the
+// group did not take part in the match.
+func spans(groups []*Location) [][2]int {
+	var s [][2]int
+	for _, g := range groups {
+		if g == nil {
+			s = append(s, [2]int{-1, -1})
+		} else {
+			s = append(s, [2]int{g.Start, g.End})
+		}
+	}
+	return s
+}
+
+func equalSpans(a [][2]int, b [][2]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubmatches(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		input    string
+		expected [][2]int
+	}{
+		{"(ab)+", "ababab", [][2]int{{0, 6}, {4, 6}}},
+		{"(aab)|(aac)", "aab", [][2]int{{0, 3}, {0, 3}, {-1, -1}}},
+		{"(aab)|(aac)", "aac", [][2]int{{0, 3}, {-1, -1}, {0, 3}}},
+		{"a(b)?c", "ac", [][2]int{{0, 2}, {-1, -1}}},
+		{"a(b)?c", "abc", [][2]int{{0, 3}, {1, 2}}},
+		{"(a|ab)(c|bcd)(d*)", "abcd", [][2]int{{0, 4}, {0, 1}, {1, 4}, {4, 4}}},
+		{"((a)|(b))+", "ab", [][2]int{{0, 2}, {1, 2}, {0, 1}, {1, 2}}},
+		{"(a*)*", "aa", [][2]int{{0, 2}, {0, 2}}},
+		{"(\\d{2,3})(\\d*)", "12345", [][2]int{{0, 5}, {0, 3}, {3, 5}}},
+		{"(x(y)){2}", "xyxy", [][2]int{{0, 4}, {2, 4}, {3, 4}}},
+		{"(日)(本+)", "日本本", [][2]int{{0, 9}, {0, 3}, {3, 9}}},
+	}
+	for _, test := range tests {
+		r := NewRegex(test.pattern)
+		actual := spans(r.Submatches(test.input))
+		if !equalSpans(actual, test.expected) {
+			t.Errorf("%q on %q: expected %v, got %v", test.pattern, test.input, test.expected, actual)
+		}
+	}
+}
+
+func TestSubmatchesNoMatch(t *testing.T) {
+	if s := NewRegex("(ab)+").Submatches("aba"); s != nil {
+		t.Error("'(ab)+' returned submatches for 'aba'", s)
+	}
+}
+
+func TestSubmatchesRunes(t *testing.T) {
+	groups := NewRegex("(日)(本+)").Submatches("日本本")
+	if groups[2].Text != "本本" || groups[2].RuneStart != 1 || groups[2].RuneEnd != 3 {
+		t.Error("'(日)(本+)' group 2 is wrong", groups[2])
+	}
+}
+
+func TestFindSubmatches(t *testing.T) {
+	r := NewRegex("(\\d+)-(\\d+)")
+	groups := r.FindSubmatches("tel: 555-1234 x")
+	expected := [][2]int{{5, 13}, {5, 8}, {9, 13}}
+	if !equalSpans(spans(groups), expected) {
+		t.Error("expected", expected, "got", spans(groups))
+	}
+	if groups[2].Text != "1234" {
+		t.Error("group 2 should be '1234', got", groups[2].Text)
+	}
+	if r.FindSubmatches("no number") != nil {
+		t.Error("found submatches in a text without numbers")
+	}
+}
+
+func TestMatcherSubmatches(t *testing.T) {
+	m := NewRegex("(ab)+c?").Matcher()
+	m.MatchNext('a')
+	if m.Submatches() != nil {
+		t.Error("submatches returned for a partial match")
+	}
+	for _, c := range "babc" {
+		m.MatchNext(c)
+	}
+	expected := [][2]int{{0, 5}, {2, 4}}
+	if actual := spans(m.Submatches()); !equalSpans(actual, expected) {
+		t.Error("expected", expected, "got", actual)
+	}
+}
+
+func TestNamedGroups(t *testing.T) {
+	for _, pattern := range []string{
+		"(?<year>\\d{4})-(?<month>\\d\\d)-(\\d\\d)",
+		"(?P<year>\\d{4})-(?P<month>\\d\\d)-(\\d\\d)",
+	} {
+		r := MustCompile(pattern)
+		if names := r.SubexpNames(); !slices.Equal(names, []string{"", "year", "month", ""}) {
+			t.Errorf("%q has names %q", pattern, names)
+		}
+		if r.SubexpIndex("month") != 2 || r.SubexpIndex("day") != -1 || r.SubexpIndex("") != -1 {
+			t.Errorf("%q has wrong group indices", pattern)
+		}
+		named := r.NamedSubmatches("2024-05-17")
+		if len(named) != 2 || named["year"].Text != "2024" || named["month"].Text != "05" {
+			t.Errorf("%q returned named submatches %v", pattern, named)
+		}
+		if r.NamedSubmatches("2024-05") != nil {
+			t.Errorf("%q returned named submatches for '2024-05'", pattern)
+		}
+	}
+}
+
+func TestNamedGroupPattern(t *testing.T) {
+	if p := NewRegex("(?P<x>a|b)c").Regex.Pattern(); p != "(?<x>a|b)c" {
+		t.Error("'(?P<x>a|b)c' has pattern", p)
+	}
+}
+
+func TestNamedGroupErrors(t *testing.T) {
+	for _, pattern := range []string{"(?<>a)", "(?<1a>a)", "(?<a-b>a)", "(?<a>x)(?<a>y)", "(?<abc", "(?<a)"} {
+		var err *SyntaxError
+		if _, e := Compile(pattern); !errors.As(e, &err) {
+			t.Errorf("%q compiled without a syntax error", pattern)
+		}
+	}
+}
+
+func TestMatcherNamedSubmatches(t *testing.T) {
+	m := NewRegex("(?<key>\\w+)=(?<value>\\w*)").Matcher()
+	for _, c := range "k=" {
+		m.MatchNext(c)
+	}
+	named := m.NamedSubmatches()
+	if named["key"].Text != "k" || named["value"] == nil || named["value"].Text != "" {
+		t.Error("'(?<key>\\w+)=(?<value>\\w*)' named submatches on 'k=' are wrong", named)
+	}
+}
@@ -6,9 +6,6 @@
 package regex
 
 import (
-	"container/list"
-	"maps"
-	"math"
 	"math/rand"
 	"slices"
 	"strconv"
@@ -20,12 +17,34 @@ type (
 	Regex interface {
 		Pattern() string
 		nfa() *automata
+
+		// emit appends the instructions of the Pike VM program recognising this
+		// regular expression, continuing at next, and returns its first instruction.
+		emit(p *program, next int) int
 	}
 
 	CompiledRegex struct {
 		Regex Regex
 		Nfa   *automata
 		Dfa   *automata
+
+		// table is the table-driven form of the DFA used for matching.
+		table *table
+
+		// program is run by the Pike VM for extracting submatches.
+		program *program
+
+		// names are the names of the capture groups by group number.
+		names []string
+
+		// source is the pattern compiled, which is parsed again when the compiled
+		// regular expression is decoded (see MarshalBinary).
+		source string
+
+		// config is the configuration it was compiled with, which Intersect, Union,
+		// Complement and Difference compile their results with. It is DefaultConfig
+		// for decoded regular expressions.
+		config Config
 	}
 
 	// choice represents the regex | regex rule
@@ -39,33 +58,51 @@ type (
 		sequence []Regex
 	}
 
-	// zeroOrOne is for an optional regular expression (re?)
+	// zeroOrOne is for an optional regular expression (re?). Quantifiers are lazy
+	// when followed by '?' (re??), preferring fewer repetitions.
 	zeroOrOne struct {
-		opt Regex
+		opt  Regex
+		lazy bool
 	}
 
 	// zeroOrMore is for the Kleene closure (re*)
 	zeroOrMore struct {
-		re Regex
+		re   Regex
+		lazy bool
 	}
 
 	// oneOrMore is for positive closure (re+)
 	oneOrMore struct {
-		re Regex
+		re   Regex
+		lazy bool
 	}
 
-	// oneOrMore is for positive closure (re+)
+	// repeat is for counted repetition (re{min,max}), max being unbounded if there
+	// is no upper limit (re{min,}).
 	repeat struct {
 		re       Regex
-		min, max uint8
+		min, max int
+		lazy     bool
 	}
 
 	// captureGrp is for grouping regular expressions inside brackets, i.e., (re)
 	captureGroup struct {
-		re Regex
+		re    Regex
+		index int
+		name  string
+	}
+
+	// group is a non-capturing group, i.e., (?:re), optionally setting modifiers for
+	// its regular expression, e.g., (?i:re).
+	group struct {
+		re    Regex
+		flags string
 	}
 )
 
+// unbounded is the maximum of a repetition without an upper limit.
+const unbounded = -1
+
 func Escape(s string) string {
 	//str := x(s)
 	//str = str.replace("(", "\\(").
@@ -87,38 +124,226 @@ func Escape(s string) string {
 	s = strings.ReplaceAll(s, "+", "\\+")
 	s = strings.ReplaceAll(s, "*", "\\*")
 	s = strings.ReplaceAll(s, "?", "\\?")
+	s = strings.ReplaceAll(s, ".", "\\.")
+	s = strings.ReplaceAll(s, "^", "\\^")
+	s = strings.ReplaceAll(s, "$", "\\$")
+	s = strings.ReplaceAll(s, "&", "\\&")
+	s = strings.ReplaceAll(s, "~", "\\~")
 
 	return s
 }
 
-// NewRegex creates a new regular expression from the input
+// Config controls how regular expressions are compiled. The zero value compiles
+// without any of the optional steps; DefaultConfig is used by NewRegex, Compile and
+// MustCompile.
+type Config struct {
+	// Minimise reduces the DFA to the equivalent DFA with the least number of states.
+	Minimise bool
+
+	// MaxStates is the state budget: the maximum number of states of the NFA and of
+	// the DFA of a regular expression, or 0 for no limit. Regular expressions needing
+	// more, such as (a{1000}){1000}, are rejected with a *SizeError before their
+	// automata are built, or as soon as the subset construction exceeds it.
+	MaxStates int
+}
+
+// DefaultMaxStates is the state budget of DefaultConfig, enough for repetitions of
+// simple expressions in the tens of thousands.
+const DefaultMaxStates = 50_000
+
+// DefaultConfig is the configuration used by NewRegex, Compile and MustCompile.
+var DefaultConfig = Config{Minimise: true, MaxStates: DefaultMaxStates}
+
+// NewRegex creates a new regular expression from the input. Parsing is lenient:
+// malformed constructs, such as an unclosed bracket, are interpreted as best as
+// possible instead of failing. Use Compile to detect syntax errors.
+//
+// NewRegex panics with a *SizeError if the regular expression exceeds the state
+// budget of DefaultConfig, such as (a{1000}){1000}. Use Compile to get the error
+// instead, or a Config with no state budget (MaxStates 0) to build it regardless.
 func NewRegex(input string) *CompiledRegex {
-	group := 0
-	groups := list.New()
-	groups.PushBack(0)
-	parser := parser{[]rune(input), 0, &group, groups}
-	r := parser.regex(&modifier{caseInsensitive: false, unicode: false})
+	return DefaultConfig.NewRegex(input)
+}
+
+// Compile parses the input as a regular expression and compiles it, returning a
+// *SyntaxError if the input is malformed.
+func Compile(input string) (*CompiledRegex, error) {
+	return DefaultConfig.Compile(input)
+}
+
+// MustCompile is like Compile but panics if the input cannot be parsed. It is meant
+// for the initialisation of global variables holding compiled regular expressions.
+func MustCompile(input string) *CompiledRegex {
+	r, err := Compile(input)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// NewRegex is the same as the package-level NewRegex but uses this configuration,
+// panicking with a *SizeError if the regular expression exceeds its state budget.
+func (c Config) NewRegex(input string) *CompiledRegex {
+	p := newParser(input)
+	r, err := c.compile(p.parse(), p.names)
+	if err != nil {
+		panic(err)
+	}
+	r.source = input
+	return r
+}
+
+// Compile is the same as the package-level Compile but uses this configuration.
+func (c Config) Compile(input string) (*CompiledRegex, error) {
+	p := newParser(input)
+	r := p.parse()
+	if p.err != nil {
+		return nil, p.err
+	}
+	compiled, err := c.compile(r, p.names)
+	if err != nil {
+		return nil, err
+	}
+	compiled.source = input
+	return compiled, nil
+}
+
+// compile compiles the regular expression with the given names of its capture
+// groups by group number, returning a *SizeError if its automata exceed the state
+// budget.
+func (c Config) compile(r Regex, names []string) (*CompiledRegex, error) {
+	if err := prepare(r, c.MaxStates); err != nil {
+		return nil, err
+	}
+	if c.MaxStates > 0 && nfaSize(r) > c.MaxStates {
+		return nil, &SizeError{r.Pattern(), c.MaxStates}
+	}
 	n := r.nfa()
-	d := n.dfa()
-	return &CompiledRegex{r, n, d}
+	d := n.dfaWithin(c.MaxStates)
+	if d == nil {
+		return nil, &SizeError{r.Pattern(), c.MaxStates}
+	}
+	if c.Minimise {
+		d = d.minimise()
+	}
+	return &CompiledRegex{r, n, d, d.table(), newProgram(r, len(names)-1), names, r.Pattern(), c}, nil
+}
+
+// maxSize bounds the sizes computed by nfaSize so that they do not overflow.
+const maxSize = 1 << 30
+
+// nfaSize returns an upper bound on the number of states of the NFA of the regular
+// expression, computed without building it.
+func nfaSize(r Regex) int {
+	switch r := r.(type) {
+	case *choice:
+		return min(maxSize, nfaSize(r.left)+nfaSize(r.right)+2)
+	case *sequence:
+		size := 0
+		for _, re := range r.sequence {
+			size = min(maxSize, size+nfaSize(re))
+		}
+		return max(2, size)
+	case *zeroOrOne:
+		return nfaSize(r.opt)
+	case *zeroOrMore:
+		return nfaSize(r.re)
+	case *oneOrMore:
+		return nfaSize(r.re)
+	case *repeat:
+		copies := r.max
+		if copies == unbounded {
+			copies = min(r.min, maxSize) + 1
+		}
+		size := nfaSize(r.re)
+		if copies > 0 && size > maxSize/copies {
+			return maxSize
+		}
+		return size*copies + 2
+	case *captureGroup:
+		return nfaSize(r.re)
+	case *group:
+		return nfaSize(r.re)
+	case *intersection:
+		if r.dfa != nil {
+			return r.dfa.StateCount() + 1
+		}
+		return min(maxSize, nfaSize(r.left)+nfaSize(r.right)+2)
+	case *complement:
+		if r.dfa != nil {
+			return r.dfa.StateCount() + 1
+		}
+		return min(maxSize, nfaSize(r.re)+2)
+	default:
+		return 2
+	}
 }
 
 func (r *CompiledRegex) Matcher() *Matcher {
-	return &Matcher{Start, "", map[int]string{}, r, r.Dfa.start}
+	m := &Matcher{Compiled: r}
+	m.Reset()
+	return m
 }
 
 func (r *CompiledRegex) Match(input string) bool {
-	m := r.Matcher()
+	var s int32 = 0
 	for _, c := range input {
-		if m.MatchNext(c) == NoMatch {
+		if s = r.table.step(s, c); s == -1 {
 			return false
 		}
 	}
-	return slices.Index(r.Dfa.final, m.State) != -1
+	return r.table.final[s]
+}
+
+// Submatches matches the regular expression to the whole input and returns the
+// locations of its capture groups, indexed by group number with group 0 being the
+// whole input, or nil if the input does not match. A group which did not take part
+// in the match is nil; a group matched repeatedly reports its last iteration.
+func (r *CompiledRegex) Submatches(input string) []*Location {
+	if !r.Match(input) {
+		return nil
+	}
+	return locations(r.program.submatches([]rune(input), -1, -1), input, 0, 0)
+}
+
+// NamedSubmatches is the same as Submatches but returns the locations of the named
+// capture groups by name. A named group which did not take part in the match is nil.
+func (r *CompiledRegex) NamedSubmatches(input string) map[string]*Location {
+	return r.named(r.Submatches(input))
+}
+
+// SubexpNames returns the names of the capture groups by group number; the name of
+// group 0 (the whole match) and of unnamed groups is the empty string.
+func (r *CompiledRegex) SubexpNames() []string {
+	return slices.Clone(r.names)
+}
+
+// SubexpIndex returns the number of the capture group with the given name, or -1
+// if there is no such group.
+func (r *CompiledRegex) SubexpIndex(name string) int {
+	if name != "" {
+		return slices.Index(r.names, name)
+	}
+	return -1
+}
+
+// named maps the locations of the named capture groups to their names.
+func (r *CompiledRegex) named(groups []*Location) map[string]*Location {
+	if groups == nil {
+		return nil
+	}
+	// a name is repeated only in the operands of a Union, of which one group matches
+	named := map[string]*Location{}
+	for i, n := range r.names {
+		if n != "" && named[n] == nil {
+			named[n] = groups[i]
+		}
+	}
+	return named
 }
 
 func (r *CompiledRegex) MatchEmpty() bool {
-	return slices.Index(r.Dfa.final, r.Dfa.start) != -1
+	return r.table.final[0]
 }
 
 func (r *CompiledRegex) Generate() string {
@@ -126,16 +351,26 @@ func (r *CompiledRegex) Generate() string {
 	state := r.Dfa.start
 	trans := r.Dfa.Trans[state]
 	for len(trans) > 0 {
-		nextStates := len(trans)
+		// classes of characters outside the limits of random generation, such as
+		// non-ASCII characters when not in unicode mode, are not candidates
+		var t []char
+		for c := range trans {
+			if _, ok := c.(*inList); ok || len(c.spanSet()) > 0 {
+				t = append(t, c)
+			}
+		}
+		nextStates := len(t)
 		final := slices.Index(r.Dfa.final, state) != -1
 		if final {
 			nextStates += 1
 		}
+		if nextStates == 0 {
+			break
+		}
 		n := rand.Intn(nextStates)
 		if final && n == nextStates-1 {
 			break
 		} else {
-			t := slices.Collect(maps.Keys(trans))
 			c := t[n]
 			s.WriteString(c.random())
 			//s.WriteRune(c.spanSet().random())
@@ -163,16 +398,19 @@ func (c *choice) Pattern() string {
 //	    v  /
 //	    right
 func (c *choice) nfa() *automata {
-	a := automata{
-		Trans: make(transitions),
-		start: &stateObj{},
-		final: []state{&stateObj{}},
-	}
-
 	left := c.left.nfa()
 	right := c.right.nfa()
 
-	a.merge(left)
+	// reuse the transitions of the larger sub-automaton: long alternations are nested
+	// to the right and copying them at every level would be quadratic
+	if len(left.Trans) < len(right.Trans) {
+		left, right = right, left
+	}
+	a := automata{
+		Trans: left.Trans,
+		start: newState(),
+		final: []state{newState()},
+	}
 	a.merge(right)
 
 	a.addTransitions(a.start, map[char]state{&empty{}: left.start, &empty{}: right.start})
@@ -182,6 +420,12 @@ func (c *choice) nfa() *automata {
 	return &a
 }
 
+func (c *choice) emit(p *program, next int) int {
+	left := c.left.emit(p, next)
+	right := c.right.emit(p, next)
+	return p.split(left, right)
+}
+
 func (s *sequence) Pattern() string {
 	ret := ""
 	//ret := "Seq("
@@ -207,8 +451,8 @@ func (s *sequence) Pattern() string {
 func (s *sequence) nfa() *automata {
 	a := automata{
 		Trans: make(transitions),
-		start: &stateObj{},
-		final: []state{&stateObj{}},
+		start: newState(),
+		final: []state{newState()},
 	}
 
 	first := true
@@ -229,8 +473,15 @@ func (s *sequence) nfa() *automata {
 	return &a
 }
 
+func (s *sequence) emit(p *program, next int) int {
+	for i := len(s.sequence) - 1; i >= 0; i-- {
+		next = s.sequence[i].emit(p, next)
+	}
+	return next
+}
+
 func (r *zeroOrOne) Pattern() string {
-	return r.opt.Pattern() + "?"
+	return r.opt.Pattern() + "?" + lazySuffix(r.lazy)
 	//return "?(" + r.opt.Pattern() + ")"
 }
 
@@ -246,8 +497,12 @@ func (r *zeroOrOne) nfa() *automata {
 	return opt
 }
 
+func (r *zeroOrOne) emit(p *program, next int) int {
+	return p.choose(r.opt.emit(p, next), next, r.lazy)
+}
+
 func (r *zeroOrMore) Pattern() string {
-	return r.re.Pattern() + "*"
+	return r.re.Pattern() + "*" + lazySuffix(r.lazy)
 	//return "*(" + r.re.Pattern() + ")"
 }
 
@@ -267,8 +522,14 @@ func (r *zeroOrMore) nfa() *automata {
 	return re
 }
 
+func (r *zeroOrMore) emit(p *program, next int) int {
+	loop := p.choose(-1, next, r.lazy)
+	p.setBody(loop, r.re.emit(p, loop))
+	return loop
+}
+
 func (r *oneOrMore) Pattern() string {
-	return r.re.Pattern() + "+"
+	return r.re.Pattern() + "+" + lazySuffix(r.lazy)
 	//return "+(" + r.re.Pattern() + ")"
 }
 
@@ -284,80 +545,112 @@ func (r *oneOrMore) nfa() *automata {
 	return re
 }
 
+func (r *oneOrMore) emit(p *program, next int) int {
+	loop := p.choose(-1, next, r.lazy)
+	body := r.re.emit(p, loop)
+	p.setBody(loop, body)
+	return body
+}
+
 func (r *repeat) Pattern() string {
 	s := r.re.Pattern() + "{"
 	if r.min == r.max {
-		s += strconv.Itoa(int(r.min))
+		s += strconv.Itoa(r.min)
 	} else {
 		if r.min != 0 {
-			s += strconv.Itoa(int(r.min))
+			s += strconv.Itoa(r.min)
 		}
 		s += ","
-		if r.max != math.MaxUint8 {
-			s += strconv.Itoa(int(r.max))
+		if r.max != unbounded {
+			s += strconv.Itoa(r.max)
 		}
 	}
-	return s + "}"
+	return s + "}" + lazySuffix(r.lazy)
 	//return "*(" + r.re.Pattern() + ")"
 }
 
 // automata generates a finite automaton for a range (m,n) repetition of the pattern.
+// The n-m optional repetitions are nested, each one skipping to the final state
+// instead of to the next one, so that the states reachable through empty transitions
+// do not grow with the number of repetitions (r{0,3} is built as (r(r(r)?)?)?).
 //
-//	                              ___________________
-//							     /   _______________ \
-//		    			  	    /   /           ___ \ \
-//	         +-m times--+      /   /           /   \ \ \
-//	         |          |     ^   ^           ^     v v v
+//	                           __________________________
+//	                          /        ____________      \
+//	         +-m times--+    /        /       ___  \      \
+//	         |          |   ^        ^       ^   v  v      v
 //	start -> r -> ...-> r -> r -> r -> ... -> r ->  final
 //	                         |                |
 //	                         +---n-m times----+
+//
+// Without an upper limit, the last repetition is followed by r*.
+//
+// An automaton has no counters, so the states of r are copied for each of the n
+// repetitions (m+1 without an upper limit): only the empty transitions between them
+// are kept linear. The state budget rejects repetitions for which the copies would be
+// too large, estimated by nfaSize before they are built.
 func (r *repeat) nfa() *automata {
 	a := &automata{
 		Trans: make(transitions),
-		start: &stateObj{},
-		final: []state{&stateObj{}},
+		start: newState(),
 	}
-	first := true
-	if r.min > 0 {
-		s := &sequence{slices.Repeat([]Regex{r.re}, int(r.min))}
-		a = s.nfa()
-		first = false
+	last := a.start
+	for range r.min {
+		re := r.re.nfa()
+		a.merge(re)
+		a.addTransitions(last, map[char]state{&empty{}: re.start})
+		last = re.final[0]
 	}
-	if r.max > r.min {
-		if r.max == 255 {
+	if r.max == unbounded {
+		re := r.re.nfa()
+		a.merge(re)
+		a.addTransitions(last, map[char]state{&empty{}: re.start})
+		a.addTransitions(re.start, map[char]state{&empty{}: re.final[0]})
+		a.addTransitions(re.final[0], map[char]state{&empty{}: re.start})
+		last = re.final[0]
+	} else if r.max > r.min {
+		final := newState()
+		for range r.max - r.min {
 			re := r.re.nfa()
 			a.merge(re)
-			a.addTransitions(re.start, map[char]state{&empty{}: re.final[0]})
-			a.addTransitions(re.final[0], map[char]state{&empty{}: re.start})
-			if first {
-				a.start = re.start
-				first = false
-			} else {
-				a.addTransitions(a.final[0], map[char]state{&empty{}: re.start})
-			}
-			a.final = re.final
-		} else {
-			for i := r.min; i < r.max; i++ {
-				re := r.re.nfa()
-				a.merge(re)
-				a.addTransitions(re.start, map[char]state{&empty{}: re.final[0]})
-				if first {
-					a.start = re.start
-					first = false
-				} else {
-					a.addTransitions(a.final[0], map[char]state{&empty{}: re.start})
-				}
-				a.final = re.final
-			}
+			a.addTransitions(last, map[char]state{&empty{}: re.start, &empty{}: final})
+			last = re.final[0]
 		}
+		a.addTransitions(last, map[char]state{&empty{}: final})
+		last = final
 	}
-	if first {
-		a.final[0] = a.start
-	}
+	a.final = []state{last}
 	return a
 }
 
+// emit unrolls the repetition as r{m} followed by n-m nested optional r, or by r*
+// if there is no upper limit.
+func (r *repeat) emit(p *program, next int) int {
+	if r.max == unbounded {
+		next = (&zeroOrMore{r.re, r.lazy}).emit(p, next)
+	} else {
+		end := next
+		for range r.max - r.min {
+			next = p.choose(r.re.emit(p, next), end, r.lazy)
+		}
+	}
+	for range r.min {
+		next = r.re.emit(p, next)
+	}
+	return next
+}
+
+// lazySuffix returns the suffix of a lazy quantifier.
+func lazySuffix(lazy bool) string {
+	if lazy {
+		return "?"
+	}
+	return ""
+}
+
 func (r *captureGroup) Pattern() string {
+	if r.name != "" {
+		return "(?<" + r.name + ">" + r.re.Pattern() + ")"
+	}
 	return "(" + r.re.Pattern() + ")"
 	//return "Grp(" + r.re.Pattern() + ")"
 }
@@ -365,3 +658,21 @@ func (r *captureGroup) Pattern() string {
 func (r *captureGroup) nfa() *automata {
 	return r.re.nfa()
 }
+
+func (r *captureGroup) emit(p *program, next int) int {
+	next = p.add(inst{op: opSave, out: next, slot: 2*r.index + 1})
+	next = r.re.emit(p, next)
+	return p.add(inst{op: opSave, out: next, slot: 2 * r.index})
+}
+
+func (r *group) Pattern() string {
+	return "(?" + r.flags + ":" + r.re.Pattern() + ")"
+}
+
+func (r *group) nfa() *automata {
+	return r.re.nfa()
+}
+
+func (r *group) emit(p *program, next int) int {
+	return r.re.emit(p, next)
+}
@@ -3,33 +3,46 @@
 // Parses regular expression to this grammar:
 //
 //	re -> re '|' re
+//	    | re '&' re
+//	    | '~' re
 //	    | re ('*' | '+' | '?')
 //	    | re re
 //	    | '(' re ')'
 //	    | ch
 //
-//	ch -> '[' '^'? (c ['-' c])+ ']'
+//	ch -> '[' '^'? set ']'
 //	    | c
+//
+//	set -> (c ['-' c] | class | '[' '^'? set ']')+ [('--' | '&&') set]
 //	    | '\' ('*' | '+' | '?' | '|' | '(' | ')' | '[' | ']')
 //
 //	Refactored to remove left-recursion and ambiguity:
 //	using: A = Aa|B  =>  A  = BA'
 //	                     A' = aA'|e
 //
-//	regex  = term ['|' regex]
-//	term   = { factor }
-//	factor = base [('*' | '+' | '?')]
-//	base   = '(' regex ')'
+//	regex  = inter ['|' regex]
+//	inter  = term ['&' inter]
+//	term   = { factor | '(?' flags ')' }
+//	factor = base [('*' | '+' | '?' | '{' m [',' [n]] '}') ['?']]
+//	base   = '~' base
+//	       | '(' regex ')'
+//	       | '(?<' name '>' regex ')'
+//	       | '(?P<' name '>' regex ')'
+//	       | '(?' flags ':' regex ')'
 //	       | ch
+//	flags  = { 'i' | 'u' } [ '-' { 'i' | 'u' } ]
 
 package regex
 
 import (
 	"container/list"
+	"io/fs"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
-	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
 )
 
 // ----------------Regex top-down parsing----------------//
@@ -39,6 +52,18 @@ type (
 		position int
 		group    *int
 		groups   *list.List
+
+		// names are the names of the capture groups by group number, empty for
+		// unnamed groups.
+		names []string
+
+		// assertions is the number of assertions parsed, for rejecting those in the
+		// operands of intersections and complements.
+		assertions int
+
+		// err is the first syntax error found. Parsing is lenient and carries on
+		// after an error; Compile reports it while NewRegex ignores it.
+		err *SyntaxError
 	}
 
 	modifier struct {
@@ -47,6 +72,31 @@ type (
 	}
 )
 
+func newParser(input string) *parser {
+	group := 0
+	groups := list.New()
+	groups.PushBack(0)
+	return &parser{input: []rune(input), group: &group, groups: groups, names: []string{""}}
+}
+
+// parse parses the whole input as a regular expression.
+func (r *parser) parse() Regex {
+	re := r.regex(&modifier{caseInsensitive: false, unicode: false})
+	if r.hasMore() {
+		// only an unbalanced ')' stops the top-level regex before the end of the input
+		r.fail(r.position, ")", "unmatched closing parenthesis", "end of pattern")
+	}
+	return re
+}
+
+// fail records a syntax error at the offset (in runes) of the offending construct.
+// Only the first error is kept as subsequent ones are usually a consequence of it.
+func (r *parser) fail(offset int, construct string, msg string, expected string) {
+	if r.err == nil {
+		r.err = &SyntaxError{string(r.input), offset, construct, msg, expected}
+	}
+}
+
 func (r *parser) peek() rune {
 	if r.position < len(r.input) {
 		return r.input[r.position]
@@ -66,7 +116,7 @@ func (r *parser) hasMore() bool {
 }
 
 func (r *parser) regex(mod *modifier) Regex {
-	term := r.term(mod)
+	term, mod := r.intersection(mod)
 	if r.hasMore() && r.peek() == '|' {
 		r.next()
 		right := r.regex(mod)
@@ -76,15 +126,105 @@ func (r *parser) regex(mod *modifier) Regex {
 	}
 }
 
-func (r *parser) term(mod *modifier) Regex {
+// intersection parses terms separated by '&', which binds more tightly than '|', and
+// returns them with the modifiers in effect at the end of the last one.
+func (r *parser) intersection(mod *modifier) (Regex, *modifier) {
+	start, assertions := r.position, r.assertions
+	left, mod := r.term(mod)
+	if !r.hasMore() || r.peek() != '&' {
+		return left, mod
+	}
+	r.next()
+	right, mod := r.intersection(mod)
+	if r.assertions != assertions {
+		r.fail(start, string(r.input[start:r.position]), "assertion in intersection", "an expression without assertions")
+	}
+	return &intersection{left: left, right: right}, mod
+}
+
+// term parses a sequence of factors and returns it with the modifiers in effect at
+// its end: modifiers set by (?flags) apply up to the end of the enclosing group,
+// including its subsequent alternatives.
+func (r *parser) term(mod *modifier) (Regex, *modifier) {
 	var factors []Regex
-	for r.hasMore() && r.peek() != ')' && r.peek() != '|' {
+	for r.hasMore() && r.peek() != ')' && r.peek() != '|' && r.peek() != '&' {
+		if r.isModifierGroup() {
+			start := r.position
+			r.position += 2
+			mod, _ = r.modifiers(mod, start)
+			for r.next() != ')' {
+			}
+			continue
+		}
 		f := r.factor(mod)
 		if f != nil {
 			factors = append(factors, f)
 		}
 	}
-	return &sequence{factors}
+	return &sequence{factors}, mod
+}
+
+// isModifierGroup returns true if the input at the current position is a group
+// setting modifiers for the rest of the enclosing group, such as (?i) or (?i-u).
+func (r *parser) isModifierGroup() bool {
+	if r.position+2 >= len(r.input) || r.input[r.position] != '(' || r.input[r.position+1] != '?' {
+		return false
+	}
+	for _, c := range r.input[r.position+2:] {
+		if c == ')' {
+			return true
+		}
+		if !unicode.IsLetter(c) && c != '-' {
+			return false
+		}
+	}
+	return false
+}
+
+// modifiers parses the modifier flags of a group, after its opening '(?', up to its
+// closing ')' or ':' which is not consumed. The flags before a '-' are set and those
+// after it are cleared in a copy of mod which is returned, with the flags as written.
+func (r *parser) modifiers(mod *modifier, start int) (*modifier, string) {
+	m := *mod
+	from := r.position
+	set := true
+	for r.hasMore() && r.peek() != ')' && r.peek() != ':' {
+		c := r.next()
+		switch {
+		case c == '-' && set:
+			set = false
+		case c == 'i':
+			m.caseInsensitive = set
+		case c == 'u':
+			m.unicode = set
+		default:
+			r.fail(start, string(r.input[start:r.position]), "unknown modifier", "'i' or 'u'")
+			r.position--
+			return &m, string(r.input[from:r.position])
+		}
+	}
+	return &m, string(r.input[from:r.position])
+}
+
+// lazy consumes the '?' following a quantifier which makes it lazy, returning true if
+// there is one.
+func (r *parser) lazy() bool {
+	if r.hasMore() && r.peek() == '?' {
+		r.next()
+		return true
+	}
+	return false
+}
+
+// count parses the number of repetitions in a counted repetition starting at start,
+// returning 0 if it is not a non-negative number.
+func (r *parser) count(start int, n string) int {
+	x, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || x < 0 {
+		r.fail(start, string(r.input[start:r.position]), "invalid repetition count", "a number")
+		return 0
+	}
+	return x
 }
 
 func (r *parser) factor(mod *modifier) Regex {
@@ -93,22 +233,25 @@ func (r *parser) factor(mod *modifier) Regex {
 		switch r.peek() {
 		case '*':
 			r.next()
-			return &zeroOrMore{base}
+			return &zeroOrMore{base, r.lazy()}
 		case '+':
 			r.next()
-			return &oneOrMore{base}
+			return &oneOrMore{base, r.lazy()}
 		case '?':
 			r.next()
-			return &zeroOrOne{base}
+			return &zeroOrOne{base, r.lazy()}
 		case '{':
+			start := r.position
 			r.next()
 			m := ""
 			n := ""
 			first := true
+			closed := false
 			if r.hasMore() {
 				for r.hasMore() {
 					c := r.next()
 					if c == '}' {
+						closed = true
 						break
 					}
 					if c == ',' {
@@ -119,37 +262,25 @@ func (r *parser) factor(mod *modifier) Regex {
 						n += string(c)
 					}
 				}
-				var mi, ma int32
-				if len(strings.TrimSpace(m)) == 0 {
-					mi = 0
-				} else {
-					x, err := strconv.Atoi(m)
-					if err != nil {
-						mi = 0
-					} else {
-						mi = int32(x)
-					}
+				if !closed {
+					r.fail(start, string(r.input[start:r.position]), "missing closing brace", "'}'")
 				}
-				if len(strings.TrimSpace(n)) == 0 {
-					ma = math.MaxUint8
-				} else {
-					x, err := strconv.Atoi(n)
-					if err != nil {
-						ma = math.MaxUint8
-					} else {
-						ma = int32(x)
-					}
+				mi, ma := 0, unbounded
+				if len(strings.TrimSpace(m)) > 0 {
+					mi = r.count(start, m)
 				}
 				if first {
 					ma = mi
+				} else if len(strings.TrimSpace(n)) > 0 {
+					ma = r.count(start, n)
 				}
-				mi = min(math.MaxUint8, max(0, mi))
-				ma = min(math.MaxUint8, max(0, ma))
-				if mi > ma {
-					ma = atomic.SwapInt32(&mi, ma)
+				if ma != unbounded && mi > ma {
+					r.fail(start, string(r.input[start:r.position]), "invalid repetition range", "minimum not greater than maximum")
+					mi, ma = ma, mi
 				}
-				return &repeat{base, uint8(mi), uint8(ma)}
+				return &repeat{base, mi, ma, r.lazy()}
 			} else {
+				r.fail(start, "{", "missing closing brace", "repetition count and '}'")
 				return &singleChar{mod, '{', cp(r.groups)}
 			}
 		}
@@ -158,25 +289,47 @@ func (r *parser) factor(mod *modifier) Regex {
 }
 
 func (r *parser) base(mod *modifier) Regex {
-	if r.peek() == '(' {
+	if r.peek() == '~' {
+		start, assertions := r.position, r.assertions
+		r.next()
+		if !r.hasMore() || r.peek() == ')' || r.peek() == '|' || r.peek() == '&' {
+			r.fail(start, "~", "missing argument to complement", "an expression after '~'")
+			return &complement{re: &sequence{}}
+		}
+		re := r.base(mod)
+		if r.assertions != assertions {
+			r.fail(start, string(r.input[start:r.position]), "assertion in complement", "an expression without assertions")
+		}
+		return &complement{re: re}
+	} else if r.peek() == '(' {
+		start := r.position
 		r.next()
 		if r.peek() == '?' {
-			// modifiers
 			r.next()
-			if r.hasMore() {
-				switch r.next() {
-				case 'i':
-					mod.caseInsensitive = true
-				case 'u':
-					mod.unicode = true
+			if r.peek() == '<' || (r.peek() == 'P' && r.position+1 < len(r.input) && r.input[r.position+1] == '<') {
+				// named capture group: (?<name>re) or (?P<name>re)
+				if r.next() == 'P' {
+					r.next()
 				}
+				return r.captureGroup(mod, start, r.groupName(start))
 			}
 
+			// non-capturing group with optional modifiers: (?:re), (?i:re), (?i-u:re)
+			m, flags := r.modifiers(mod, start)
+			if r.peek() == ':' {
+				r.next()
+			} else {
+				r.fail(start, string(r.input[start:r.position]), "missing colon", "':' or ')'")
+			}
+			re := r.regex(m)
+
 			// lenient parsing: don't break if no closing bracket, read to the end
 			if r.hasMore() {
 				r.next()
+			} else {
+				r.fail(start, "(?", "missing closing parenthesis", "')'")
 			}
-			return nil
+			return &group{re, flags}
 		} else if r.peek() == ':' {
 			// list
 			r.next()
@@ -186,113 +339,331 @@ func (r *parser) base(mod *modifier) Regex {
 			}
 			if r.hasMore() {
 				r.next()
+			} else {
+				r.fail(start, string(r.input[start:r.position]), "missing closing parenthesis", "')'")
+			}
+			if _, err := fs.Stat(lists, "lists/"+list.String()); err != nil {
+				r.fail(start, string(r.input[start:r.position]), "unknown list", "one of the embedded lists")
 			}
 			return &inList{
-				mod: mod,
+				mod:  mod,
 				list: list.String(),
 			}
 		} else {
-			*r.group++
-			r.groups.PushBack(*r.group)
-
-			re := r.regex(mod)
-			r.groups.Remove(r.groups.Back())
-
-			// lenient parsing: don't break if no closing bracket, read to the end
-			if r.hasMore() {
-				r.next()
-			}
-			return &captureGroup{re}
+			return r.captureGroup(mod, start, "")
 		}
 	} else {
 		return r.ch(mod)
 	}
 }
 
-func (r *parser) ch(mod *modifier) Regex {
-	if r.peek() == '[' {
+// captureGroup parses the regular expression of a capture group, after its opening,
+// up to and including its closing bracket.
+func (r *parser) captureGroup(mod *modifier, start int, name string) Regex {
+	*r.group++
+	index := *r.group
+	r.groups.PushBack(index)
+	r.names = append(r.names, name)
+
+	re := r.regex(mod)
+	r.groups.Remove(r.groups.Back())
+
+	// lenient parsing: don't break if no closing bracket, read to the end
+	if r.hasMore() {
 		r.next()
+	} else {
+		r.fail(start, "(", "missing closing parenthesis", "')'")
+	}
+	return &captureGroup{re, index, name}
+}
 
-		exclude := false
-		if r.peek() == '^' {
-			r.next()
-			exclude = true
+// groupName parses the name of a named capture group, after its opening '<', up to
+// and including the closing '>'. Names are made of letters, digits and underscores
+// and cannot start with a digit.
+func (r *parser) groupName(start int) string {
+	var name strings.Builder
+	for r.hasMore() && r.peek() != '>' && r.peek() != ')' {
+		name.WriteRune(r.next())
+	}
+	if r.peek() == '>' {
+		r.next()
+	} else {
+		r.fail(start, string(r.input[start:r.position]), "missing closing angle bracket of group name", "'>'")
+	}
+	n := name.String()
+	valid := len(n) > 0
+	for i, c := range n {
+		if !(c == '_' || unicode.IsLetter(c) || (i > 0 && unicode.IsDigit(c))) {
+			valid = false
 		}
+	}
+	if !valid {
+		r.fail(start, string(r.input[start:r.position]), "invalid group name", "a name of letters, digits and '_'")
+	} else if slices.Index(r.names, n) != -1 {
+		r.fail(start, string(r.input[start:r.position]), "duplicate group name", "a unique group name")
+	}
+	return n
+}
 
-		charSets := list.New()
-		for r.hasMore() && r.peek() != ']' {
-			from := r.next()
-			if r.peek() == '-' {
-				r.next()
-				if r.hasMore() && r.peek() != ']' {
-					to := r.next()
-					charSets.PushBack(&charRange{mod, from, to, cp(r.groups)})
-				} else {
-					charSets.PushBack(&charRange{mod, from, math.MaxUint8, cp(r.groups)})
-				}
-			} else {
-				charSets.PushBack(&singleChar{mod, from, cp(r.groups)})
-			}
-		}
-		// lenient parsing: don't break if no closing square bracket, read to the end
-		if r.hasMore() {
-			r.next()
-		}
-		return &charSet{mod, exclude, *charSets, cp(r.groups)}
+// assertion returns an assertion of the kind written as pattern, counting it.
+func (r *parser) assertion(kind assertKind, pattern string) Regex {
+	r.assertions++
+	return &assertion{kind, pattern}
+}
 
+func (r *parser) ch(mod *modifier) Regex {
+	start := r.position
+	if r.peek() == '[' {
+		r.next()
+		return r.charSet(mod, start)
 	} else if r.peek() == '\\' {
 		r.next()
 		// lenient parsing: a single backlash at the end is interpreted as escaping itself
 		if r.hasMore() {
 			switch c := r.next(); c {
-			case 'd':
-				return &charRange{mod, '0', '9', cp(r.groups)}
-			case 'D':
-				cs := list.New()
-				cs.PushBack(&charRange{mod, '0', '9', cp(r.groups)})
-				return &charSet{mod, true, *cs, cp(r.groups)}
-			case 's':
-				cs := list.New()
-				cs.PushBack(&singleChar{mod, ' ', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '\t', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '\n', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '\f', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '\r', cp(r.groups)})
-				return &charSet{mod, false, *cs, cp(r.groups)}
-			case 'S':
-				cs := list.New()
-				cs.PushBack(&singleChar{mod, ' ', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '\t', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '\n', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '\f', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '\r', cp(r.groups)})
-				return &charSet{mod, true, *cs, cp(r.groups)}
-			case 'w':
-				cs := list.New()
-				cs.PushBack(&charRange{mod, '0', '9', cp(r.groups)})
-				cs.PushBack(&charRange{mod, 'a', 'z', cp(r.groups)})
-				cs.PushBack(&charRange{mod, 'A', 'Z', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '_', cp(r.groups)})
-				return &charSet{mod, false, *cs, cp(r.groups)}
-			case 'W':
-				cs := list.New()
-				cs.PushBack(&charRange{mod, '0', '9', cp(r.groups)})
-				cs.PushBack(&charRange{mod, 'a', 'z', cp(r.groups)})
-				cs.PushBack(&charRange{mod, 'A', 'Z', cp(r.groups)})
-				cs.PushBack(&singleChar{mod, '_', cp(r.groups)})
-				return &charSet{mod, true, *cs, cp(r.groups)}
+			case 'd', 'D', 's', 'S', 'w', 'W':
+				return r.perlClass(mod, c)
+			case 'p', 'P':
+				return r.unicodeClass(mod, start, c == 'P')
+			case 'A':
+				return r.assertion(beginText, "\\A")
+			case 'z':
+				return r.assertion(endText, "\\z")
+			case 'b':
+				return r.assertion(wordBoundary, "\\b")
+			case 'B':
+				return r.assertion(notWordBoundary, "\\B")
+			case 'x':
+				return &singleChar{mod, r.hexEscape(start), cp(r.groups)}
 			default:
 				return &singleChar{mod, c, cp(r.groups)}
 			}
 		} else {
+			r.fail(start, "\\", "trailing backslash", "an escaped character")
 			return &singleChar{mod, '\\', cp(r.groups)}
 		}
 	} else if r.peek() == '.' {
 		r.next()
 		return &anyChar{mod: mod}
+	} else if r.peek() == '^' {
+		r.next()
+		return r.assertion(beginText, "^")
+	} else if r.peek() == '$' {
+		r.next()
+		return r.assertion(endText, "$")
+	} else {
+		c := r.next()
+		if c == '*' || c == '+' || c == '?' {
+			r.fail(start, string(c), "missing argument to repetition operator", "an expression before '"+string(c)+"'")
+		}
+		return &singleChar{mod, c, cp(r.groups)}
+	}
+}
+
+// charSet parses a character set after its opening '[', up to and including its
+// closing ']'. A character set is a union of members, nested character sets
+// included, which can be combined with the set difference (--) and intersection (&&)
+// operators, evaluated from left to right.
+func (r *parser) charSet(mod *modifier, start int) char {
+	exclude := false
+	if r.peek() == '^' {
+		r.next()
+		exclude = true
+	}
+	left := r.charSetUnion(mod)
+	if left.sets.Len() == 0 && r.isSetOperator() {
+		r.fail(r.position, string(r.input[r.position:r.position+2]), "missing operand of set operation", "a character set")
+	}
+	var set char = left
+	for r.isSetOperator() {
+		opStart := r.position
+		op := r.next()
+		r.next()
+		right := r.charSetUnion(mod)
+		if right.sets.Len() == 0 {
+			r.fail(opStart, string(r.input[opStart:r.position]), "missing operand of set operation", "a character set")
+		}
+		set = &setOperation{mod, op, set, right, cp(r.groups)}
+	}
+
+	// lenient parsing: don't break if no closing square bracket, read to the end
+	if r.hasMore() {
+		r.next()
 	} else {
-		return &singleChar{mod, r.next(), cp(r.groups)}
+		r.fail(start, "[", "missing closing square bracket", "']'")
+	}
+	if union, ok := set.(*charSet); ok {
+		union.exclude = exclude
+		return union
+	} else if exclude {
+		sets := list.New()
+		sets.PushBack(set)
+		return &charSet{mod, true, *sets, cp(r.groups)}
+	}
+	return set
+}
+
+// isSetOperator returns true if the input at the current position is a set
+// difference (--) or intersection (&&) operator in a character set.
+func (r *parser) isSetOperator() bool {
+	if r.position+1 >= len(r.input) {
+		return false
+	}
+	c := r.input[r.position]
+	return (c == '-' || c == '&') && r.input[r.position+1] == c
+}
+
+// charSetUnion parses the members of a character set up to its end or to a set
+// operator. Members are single characters, ranges, classes and nested character sets.
+func (r *parser) charSetUnion(mod *modifier) *charSet {
+	charSets := list.New()
+	for r.hasMore() && r.peek() != ']' && !r.isSetOperator() {
+		atomStart := r.position
+		if r.peek() == '[' && (r.position+1 >= len(r.input) || r.input[r.position+1] != ':') {
+			r.next()
+			charSets.PushBack(r.charSet(mod, atomStart))
+			continue
+		}
+		class, from := r.classAtom(mod)
+		if class != nil {
+			charSets.PushBack(class)
+		} else if r.peek() == '-' && !r.isSetOperator() {
+			r.next()
+			if r.hasMore() && r.peek() != ']' {
+				class, to := r.classAtom(mod)
+				if class != nil {
+					r.fail(atomStart, string(r.input[atomStart:r.position]), "invalid character range", "a character")
+					charSets.PushBack(&singleChar{mod, from, cp(r.groups)})
+					charSets.PushBack(&singleChar{mod, '-', cp(r.groups)})
+					charSets.PushBack(class)
+				} else {
+					charSets.PushBack(&charRange{mod, from, to, cp(r.groups)})
+				}
+			} else {
+				charSets.PushBack(&charRange{mod, from, math.MaxUint8, cp(r.groups)})
+			}
+		} else {
+			charSets.PushBack(&singleChar{mod, from, cp(r.groups)})
+		}
+	}
+	return &charSet{mod, false, *charSets, cp(r.groups)}
+}
+
+// classAtom parses a member of a character set: either a class of characters, such as
+// \d, \p{L} or [:alpha:], which is returned, or a single, possibly escaped, character
+// which is returned as a rune with a nil class.
+func (r *parser) classAtom(mod *modifier) (char, rune) {
+	start := r.position
+	if r.peek() == '[' && r.position+1 < len(r.input) && r.input[r.position+1] == ':' {
+		// POSIX class: [:name:] or [:^name:]
+		end := slices.Index(r.input[r.position+2:], ':')
+		if end != -1 && r.position+2+end+1 < len(r.input) && r.input[r.position+2+end+1] == ']' {
+			name := string(r.input[r.position+2 : r.position+2+end])
+			r.position += 2 + end + 2
+			negated := strings.HasPrefix(name, "^")
+			spans, ok := posixClasses[strings.TrimPrefix(name, "^")]
+			if !ok {
+				r.fail(start, string(r.input[start:r.position]), "unknown POSIX class", "a POSIX class such as [:alpha:]")
+			}
+			return newUnicodeClass(mod, string(r.input[start:r.position]), spans, negated, r.groups), 0
+		}
+	}
+	c := r.next()
+	if c == '\\' {
+		if !r.hasMore() {
+			r.fail(start, "\\", "trailing backslash", "an escaped character")
+			return nil, c
+		}
+		switch c = r.next(); c {
+		case 'd', 'D', 's', 'S', 'w', 'W':
+			return r.perlClass(mod, c), 0
+		case 'p', 'P':
+			return r.unicodeClass(mod, start, c == 'P'), 0
+		case 'x':
+			return nil, r.hexEscape(start)
+		}
+	}
+	return nil, c
+}
+
+// hexEscape parses the code of a character after \x, either two hexadecimal digits
+// (\x7f) or up to six in braces (\x{10ffff}), and returns the character.
+func (r *parser) hexEscape(start int) rune {
+	braces := r.peek() == '{'
+	if braces {
+		r.next()
+	}
+	from := r.position
+	for r.hasMore() && (braces && r.peek() != '}' || !braces && r.position-from < 2) {
+		r.next()
+	}
+	digits := string(r.input[from:r.position])
+	if braces {
+		if !r.hasMore() {
+			r.fail(start, string(r.input[start:]), "missing closing brace", "'}'")
+			return utf8.RuneError
+		}
+		r.next()
+	}
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || braces && len(digits) > 6 || !braces && len(digits) < 2 || code > unicode.MaxRune {
+		r.fail(start, string(r.input[start:r.position]), "invalid hexadecimal escape", "2 hexadecimal digits, or up to 6 in braces")
+		return utf8.RuneError
+	}
+	return rune(code)
+}
+
+// perlClass returns the class of characters of \d, \D, \s, \S, \w or \W.
+func (r *parser) perlClass(mod *modifier, c rune) char {
+	cs := list.New()
+	switch unicode.ToLower(c) {
+	case 'd':
+		if c == 'd' {
+			return &charRange{mod, '0', '9', cp(r.groups)}
+		}
+		cs.PushBack(&charRange{mod, '0', '9', cp(r.groups)})
+	case 's':
+		cs.PushBack(&singleChar{mod, ' ', cp(r.groups)})
+		cs.PushBack(&singleChar{mod, '\t', cp(r.groups)})
+		cs.PushBack(&singleChar{mod, '\n', cp(r.groups)})
+		cs.PushBack(&singleChar{mod, '\f', cp(r.groups)})
+		cs.PushBack(&singleChar{mod, '\r', cp(r.groups)})
+	default:
+		cs.PushBack(&charRange{mod, '0', '9', cp(r.groups)})
+		cs.PushBack(&charRange{mod, 'a', 'z', cp(r.groups)})
+		cs.PushBack(&charRange{mod, 'A', 'Z', cp(r.groups)})
+		cs.PushBack(&singleChar{mod, '_', cp(r.groups)})
+	}
+	return &charSet{mod, unicode.IsUpper(c), *cs, cp(r.groups)}
+}
+
+// unicodeClass parses a unicode general category or script after \p or \P, either
+// named by a single letter (\pL) or in braces (\p{Greek}), negated by \P or by a
+// '^' in braces (\p{^Greek}).
+func (r *parser) unicodeClass(mod *modifier, start int, negated bool) char {
+	var name string
+	if r.peek() == '{' {
+		r.next()
+		end := slices.Index(r.input[r.position:], '}')
+		if end == -1 {
+			r.position = len(r.input)
+			r.fail(start, string(r.input[start:]), "missing closing brace", "'}'")
+			return newUnicodeClass(mod, string(r.input[start:]), nil, negated, r.groups)
+		}
+		name = string(r.input[r.position : r.position+end])
+		r.position += end + 1
+		if strings.HasPrefix(name, "^") {
+			name = name[1:]
+			negated = !negated
+		}
+	} else if r.hasMore() {
+		name = string(r.next())
+	}
+	spans := unicodeSpans(name)
+	if spans == nil {
+		r.fail(start, string(r.input[start:r.position]), "unknown unicode class", "a unicode category or script")
 	}
+	return newUnicodeClass(mod, string(r.input[start:r.position]), spans, negated, r.groups)
 }
 
 func cp(groups *list.List) list.List {
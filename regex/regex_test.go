@@ -4,6 +4,7 @@ package regex
 
 import (
 	"testing"
+	"unicode/utf8"
 )
 
 func TestX(t *testing.T) {
@@ -471,3 +472,114 @@ func TestUnicode(t *testing.T) {
 		t.Error("'.{3,3}' did not match '日本語'")
 	}
 }
+
+func TestNonCapturingGroup(t *testing.T) {
+	r := NewRegex("(?:ab)+(c)")
+	if !r.Match("ababc") {
+		t.Error("'(?:ab)+(c)' did not match 'ababc'")
+	}
+	if r.Match("abac") {
+		t.Error("'(?:ab)+(c)' matched 'abac'")
+	}
+	if names := r.SubexpNames(); len(names) != 2 {
+		t.Error("'(?:ab)+(c)' should have 1 capture group, found", len(names)-1)
+	}
+	if groups := r.Submatches("abc"); groups[1].Text != "c" {
+		t.Error("'(?:ab)+(c)' group 1 on 'abc' is", groups[1])
+	}
+}
+
+func TestScopedModifiers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		fails   []string
+	}{
+		{"a(?i:b)c", []string{"abc", "aBc"}, []string{"Abc", "abC"}},
+		{"a(?i)bc", []string{"aBC", "abc"}, []string{"ABC"}},
+		{"(a(?i)b)c", []string{"aBc"}, []string{"aBC"}},
+		{"a(?i)b|c", []string{"aB", "C"}, []string{"AB"}},
+		{"(?i)a(?-i)b", []string{"Ab"}, []string{"AB"}},
+		{"(?i)a(?-i:b)c", []string{"AbC"}, []string{"ABC"}},
+		{"(?iu)é", []string{"É"}, []string{"e"}},
+		{"(?i-u:x)y", []string{"Xy"}, []string{"XY"}},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.pattern)
+		for _, s := range test.matches {
+			if !r.Match(s) {
+				t.Errorf("%q did not match %q", test.pattern, s)
+			}
+		}
+		for _, s := range test.fails {
+			if r.Match(s) {
+				t.Errorf("%q matched %q", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestMatcherExpected(t *testing.T) {
+	m := MustCompile("[a-z]+[0-9]|_").Matcher()
+	expected := m.Expected()
+	if len(expected) != 2 || expected[0].Pattern != "_" || expected[1].Pattern != "[a-z]" {
+		t.Fatalf("expected _ and [a-z], got %v", expected)
+	}
+	if r := expected[1].Ranges; len(r) != 1 || r[0] != [2]rune{'a', 'z'} {
+		t.Errorf("expected the range a-z, got %v", r)
+	}
+	if !expected[1].Contains('m') || expected[1].Contains('A') || expected[0].Contains('a') {
+		t.Error("unexpected class membership")
+	}
+	m.MatchNext('a')
+	if expected := m.Expected(); len(expected) != 2 || !expected[0].Contains('5') || !expected[1].Contains('b') {
+		t.Errorf("expected [0-9] and [a-z], got %v", expected)
+	}
+	m.MatchNext('%')
+	if expected := m.Expected(); len(expected) != 0 {
+		t.Errorf("expected nothing after no match, got %v", expected)
+	}
+}
+
+func TestHexEscapes(t *testing.T) {
+	r := MustCompile("\\x41\\x{3a9}[\\x00-\\x1f\\x{10ffff}]")
+	for _, s := range []string{"AΩ\x00", "AΩ\x1f", "AΩ\U0010ffff"} {
+		if !r.Match(s) {
+			t.Errorf("%q did not match %q", r.Regex.Pattern(), s)
+		}
+	}
+	if r.Match("AΩ ") {
+		t.Errorf("%q matched a space", r.Regex.Pattern())
+	}
+}
+
+func TestExpectedPatterns(t *testing.T) {
+	// the pattern of each expected class compiles to a regex matching exactly its ranges
+	for _, test := range []struct{ pattern, text string }{
+		{"x[^a]", "x"},
+		{"[\\x00-\\x08\\]\\-^\\\\]", ""},
+		{"a\\x{7f}|a\\x{10ffff}|a[\\x{80}-\\x{9f}]", "a"},
+		{"[\\x{2028}]|[\t\n ]", ""},
+	} {
+		m := MustCompile(test.pattern).Matcher()
+		m.Match(test.text)
+		expected := m.Expected()
+		if len(expected) == 0 {
+			t.Errorf("%q: no class expected after %q", test.pattern, test.text)
+		}
+		for _, c := range expected {
+			r, err := Compile(c.Pattern)
+			if err != nil {
+				t.Errorf("%q: %v", c.Pattern, err)
+				continue
+			}
+			for _, rg := range c.Ranges {
+				for _, ch := range []rune{rg[0] - 1, rg[0], rg[1], rg[1] + 1} {
+					if ch >= 0 && utf8.ValidRune(ch) && r.Match(string(ch)) != c.Contains(ch) {
+						t.Errorf("%q: matching %U disagrees with the ranges %v", c.Pattern, ch, c.Ranges)
+					}
+				}
+			}
+		}
+	}
+}
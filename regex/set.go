@@ -0,0 +1,352 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"encoding/binary"
+	"maps"
+	"slices"
+	"strings"
+)
+
+type (
+	// RegexSet is a set of regular expressions compiled into a single DFA whose states
+	// record which of the regular expressions are partially and fully matched by the
+	// text leading to them, so that matching a text against all the regular expressions
+	// of the set costs the same as matching it against one. The regular expressions are
+	// identified by their index in the set, lower indices having priority over higher
+	// ones when several match.
+	RegexSet struct {
+		// Regexes are the regular expressions of the set, compiled individually.
+		Regexes []*CompiledRegex
+
+		Nfa *automata
+		Dfa *automata
+
+		// table is the table-driven form of the DFA used for matching.
+		table *table
+	}
+
+	// setMatch are the regular expressions of a set partially and fully matched in a
+	// state of its DFA, by index in increasing order.
+	setMatch struct {
+		// partial are the regular expressions of which the text leading to the state
+		// is a prefix of a match.
+		partial []int
+
+		// full are the regular expressions matching the text leading to the state, by
+		// the lookahead after it (beforeEnd, beforeOther, beforeWord).
+		full [3][]int
+	}
+
+	// SetMatcher matches a text supplied one character at a time against all the
+	// regular expressions of a set at once, reporting which ones are partially and
+	// fully matched by the text so far.
+	SetMatcher struct {
+		LastMatch MatchType
+		Matched   string
+		Set       *RegexSet
+
+		// current is the number of the state in the table of the set.
+		current int32
+
+		// previous is the character before the text matched, negative at the start of
+		// the text, for evaluating assertions.
+		previous rune
+
+		// runners follow the regular expressions of the set with lazy quantifiers, by
+		// index, until they reach their earliest acceptable end, after which they are
+		// added to complete and no longer match.
+		runners  map[int]*runner
+		complete []int
+
+		matched strings.Builder
+	}
+)
+
+// NewSet creates a set of the regular expressions parsed leniently from the patterns,
+// as NewRegex does. It panics with a *SizeError if the set exceeds the state budget.
+func NewSet(patterns ...string) *RegexSet {
+	return DefaultConfig.NewSet(patterns...)
+}
+
+// CompileSet compiles the patterns into a set of regular expressions, returning the
+// *SyntaxError of the first malformed pattern, or a *SizeError if the set exceeds the
+// state budget.
+func CompileSet(patterns ...string) (*RegexSet, error) {
+	return DefaultConfig.CompileSet(patterns...)
+}
+
+// MustCompileSet is like CompileSet but panics if a pattern cannot be parsed.
+func MustCompileSet(patterns ...string) *RegexSet {
+	s, err := CompileSet(patterns...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// SetOf creates a set of regular expressions already compiled, returning a *SizeError
+// if the set exceeds the state budget.
+func SetOf(regexes ...*CompiledRegex) (*RegexSet, error) {
+	return DefaultConfig.SetOf(regexes...)
+}
+
+// NewSet is the same as the package-level NewSet but uses this configuration.
+func (c Config) NewSet(patterns ...string) *RegexSet {
+	regexes := make([]*CompiledRegex, len(patterns))
+	for i, p := range patterns {
+		regexes[i] = c.NewRegex(p)
+	}
+	s, err := c.SetOf(regexes...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// CompileSet is the same as the package-level CompileSet but uses this configuration.
+func (c Config) CompileSet(patterns ...string) (*RegexSet, error) {
+	regexes := make([]*CompiledRegex, len(patterns))
+	for i, p := range patterns {
+		r, err := c.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		regexes[i] = r
+	}
+	return c.SetOf(regexes...)
+}
+
+// SetOf is the same as the package-level SetOf but uses this configuration for
+// building the DFA of the set.
+func (c Config) SetOf(regexes ...*CompiledRegex) (*RegexSet, error) {
+	size := 1
+	for _, r := range regexes {
+		size = min(maxSize, size+nfaSize(r.Regex))
+	}
+	if c.MaxStates > 0 && size > c.MaxStates {
+		return nil, &SizeError{setPattern(regexes), c.MaxStates}
+	}
+	n := setNfa(regexes)
+	d := n.dfaWithin(c.MaxStates)
+	if d == nil {
+		return nil, &SizeError{setPattern(regexes), c.MaxStates}
+	}
+	if c.Minimise {
+		d = d.minimise()
+	}
+	return &RegexSet{regexes, n, d, d.table()}, nil
+}
+
+// setPattern returns the patterns of the regular expressions of a set as alternatives,
+// for error messages.
+func setPattern(regexes []*CompiledRegex) string {
+	patterns := make([]string, len(regexes))
+	for i, r := range regexes {
+		patterns[i] = r.Regex.Pattern()
+	}
+	return strings.Join(patterns, "|")
+}
+
+// setNfa builds the NFA of a set of regular expressions: a start state with empty
+// transitions to the NFAs of the regular expressions, whose states are labelled with
+// the index of their regular expression.
+func setNfa(regexes []*CompiledRegex) *automata {
+	a := &automata{
+		Trans:   make(transitions),
+		start:   newState(),
+		final:   []state{},
+		pattern: map[state]int{},
+	}
+	for i, r := range regexes {
+		re := r.Regex.nfa()
+		a.merge(re)
+		a.addTransitions(a.start, map[char]state{&empty{}: re.start})
+		a.final = append(a.final, re.final[0])
+		a.pattern[re.start] = i
+		a.pattern[re.final[0]] = i
+		for s, trans := range re.Trans {
+			a.pattern[s] = i
+			for _, t := range trans {
+				a.pattern[t] = i
+			}
+		}
+	}
+	return a
+}
+
+// setMatch returns the regular expressions of the set partially and fully matched in
+// the DFA state made of the NFA states, resolve returning the states reachable from
+// them before each lookahead.
+func (auto *automata) setMatch(states set[state], resolve func(lookahead) set[state]) *setMatch {
+	m := &setMatch{}
+	partial := set[int]{}
+	for s := range states {
+		if p, ok := auto.pattern[s]; ok {
+			partial[p] = true
+		}
+	}
+	m.partial = slices.Sorted(maps.Keys(partial))
+	for next := range m.full {
+		full := set[int]{}
+		for s := range resolve(lookahead(next)) {
+			if p, ok := auto.pattern[s]; ok && auto.final[p] == s {
+				full[p] = true
+			}
+		}
+		m.full[next] = slices.Sorted(maps.Keys(full))
+	}
+	return m
+}
+
+// key identifies the regular expressions matched for partitioning states when
+// minimising the DFA of a set.
+func (m *setMatch) key() string {
+	if m == nil {
+		return ""
+	}
+	var b []byte
+	for _, indices := range [][]int{m.partial, m.full[beforeEnd], m.full[beforeOther], m.full[beforeWord]} {
+		b = binary.AppendUvarint(b, uint64(len(indices)))
+		for _, i := range indices {
+			b = binary.AppendUvarint(b, uint64(i))
+		}
+	}
+	return string(b)
+}
+
+// Matcher returns a new matcher of the text against the regular expressions of the set.
+func (s *RegexSet) Matcher() *SetMatcher {
+	m := &SetMatcher{Set: s}
+	m.Reset()
+	return m
+}
+
+// Match returns the indices, in priority order, of the regular expressions of the set
+// matching the whole input.
+func (s *RegexSet) Match(input string) []int {
+	m := s.Matcher()
+	for _, c := range input {
+		if m.MatchNext(c) == NoMatch {
+			return nil
+		}
+	}
+	return m.Full()
+}
+
+func (m *SetMatcher) Reset() {
+	m.ResetAfter(-1)
+}
+
+// ResetAfter resets the matcher for matching the text following the character
+// previous, as Matcher.ResetAfter does.
+func (m *SetMatcher) ResetAfter(previous rune) {
+	m.LastMatch = Start
+	m.Matched = ""
+	m.previous = previous
+	m.current = m.Set.table.startAfter(previous)
+	m.matched.Reset()
+	m.runners = nil
+	m.complete = nil
+	for i, r := range m.Set.Regexes {
+		if r.program.lazy {
+			if m.runners == nil {
+				m.runners = map[int]*runner{}
+			}
+			m.runners[i] = r.program.newRunner(previous)
+		}
+	}
+}
+
+// MatchNext matches the next character of the text against the regular expressions
+// of the set, returning NoMatch if none of them is partially matched any more,
+// FullMatch if one of them matches the text so far and PartialMatch otherwise. As
+// for Matcher, regular expressions with lazy quantifiers stop matching after their
+// earliest acceptable end.
+func (m *SetMatcher) MatchNext(r rune) MatchType {
+	if m.LastMatch == NoMatch {
+		return NoMatch
+	}
+	for i, run := range m.runners {
+		if _, earliest := run.at(r); earliest || !run.advance(r) {
+			delete(m.runners, i)
+			m.complete = append(m.complete, i)
+		}
+	}
+	t := m.Set.table
+	next := t.step(m.current, r)
+	if next == -1 {
+		m.LastMatch = NoMatch
+		return m.LastMatch
+	}
+	m.current = next
+	if len(m.Partial()) == 0 {
+		m.LastMatch = NoMatch
+		return m.LastMatch
+	}
+	if len(m.Full()) > 0 {
+		m.LastMatch = FullMatch
+	} else {
+		m.LastMatch = PartialMatch
+	}
+	m.matched.WriteRune(r)
+	m.Matched = m.matched.String()
+	return m.LastMatch
+}
+
+// Partial returns the indices, in priority order, of the regular expressions of the
+// set of which the text matched so far is a prefix of a match, including those it
+// fully matches.
+func (m *SetMatcher) Partial() []int {
+	if m.LastMatch == NoMatch {
+		return nil
+	}
+	if matches := m.Set.table.matches[m.current]; matches != nil {
+		return m.active(matches.partial)
+	}
+	return nil
+}
+
+// Full returns the indices, in priority order, of the regular expressions of the set
+// fully matching the text matched so far, the first one having priority.
+func (m *SetMatcher) Full() []int {
+	return m.FullBefore(-1)
+}
+
+// FullBefore returns the indices, in priority order, of the regular expressions of
+// the set fully matching the text matched so far when it is followed by the character
+// next, or by the end of the text if next is negative. It differs from Full only for
+// regular expressions ending with assertions on the next character.
+func (m *SetMatcher) FullBefore(next rune) []int {
+	if m.LastMatch == NoMatch {
+		return nil
+	}
+	if matches := m.Set.table.matches[m.current]; matches != nil {
+		return m.active(matches.full[lookaheadBefore(next)])
+	}
+	return nil
+}
+
+// active returns the regular expressions which have not reached their earliest
+// acceptable end amongst the indices.
+func (m *SetMatcher) active(indices []int) []int {
+	if len(m.complete) == 0 {
+		return indices
+	}
+	return slices.DeleteFunc(slices.Clone(indices), func(i int) bool {
+		return slices.Contains(m.complete, i)
+	})
+}
+
+// PatternMatcher returns a matcher of the regular expression of the set at the index
+// which has matched the same text after the same character, e.g., for inspecting the
+// characters it expects next.
+func (m *SetMatcher) PatternMatcher(i int) *Matcher {
+	pm := m.Set.Regexes[i].Matcher()
+	pm.ResetAfter(m.previous)
+	for _, c := range m.Matched {
+		pm.MatchNext(c)
+	}
+	return pm
+}
@@ -0,0 +1,142 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSetMatch(t *testing.T) {
+	s := MustCompileSet("let", "[a-z]+", "\\d+", "\\d+\\.\\d+", "[a-z]+\\d")
+	tests := []struct {
+		input   string
+		matches []int
+	}{
+		{"let", []int{0, 1}},
+		{"lets", []int{1}},
+		{"12", []int{2}},
+		{"1.5", []int{3}},
+		{"x1", []int{4}},
+		{"1.", nil},
+		{"", nil},
+		{"?", nil},
+	}
+	for _, test := range tests {
+		if m := s.Match(test.input); !slices.Equal(m, test.matches) {
+			t.Errorf("%q: expected %v, got %v", test.input, test.matches, m)
+		}
+	}
+}
+
+func TestSetMatcher(t *testing.T) {
+	s := MustCompileSet("if\\b", "[a-z]+", "i[a-z]*\\d")
+	m := s.Matcher()
+	if m.MatchNext('i') != FullMatch || !slices.Equal(m.Partial(), []int{0, 1, 2}) || !slices.Equal(m.Full(), []int{1}) {
+		t.Error("'i': unexpected matches", m.Partial(), m.Full())
+	}
+	m.MatchNext('f')
+	if !slices.Equal(m.FullBefore(' '), []int{0, 1}) || !slices.Equal(m.FullBefore('x'), []int{1}) {
+		t.Error("'if': unexpected full matches", m.FullBefore(' '), m.FullBefore('x'))
+	}
+	if m.MatchNext('1') != FullMatch || !slices.Equal(m.Partial(), []int{2}) || !slices.Equal(m.Full(), []int{2}) {
+		t.Error("'if1': unexpected matches", m.Partial(), m.Full())
+	}
+	if m.MatchNext('x') != NoMatch || m.Matched != "if1" || m.Partial() != nil {
+		t.Error("'if1x' matched", m.Matched)
+	}
+
+	m.ResetAfter('a')
+	if m.MatchNext('i') != FullMatch || !slices.Equal(m.Partial(), []int{0, 1, 2}) {
+		t.Error("'i' after 'a': unexpected matches", m.Partial())
+	}
+}
+
+func TestSetMatcherLazy(t *testing.T) {
+	s := MustCompileSet("<!--.*?-->", "<!--[^>]*-->x")
+	m := s.Matcher()
+	for _, c := range "<!-- a -->" {
+		m.MatchNext(c)
+	}
+	if !slices.Equal(m.Full(), []int{0}) {
+		t.Error("'<!-- a -->': unexpected full matches", m.Full())
+	}
+	if m.MatchNext('x') != FullMatch || !slices.Equal(m.Partial(), []int{1}) || !slices.Equal(m.Full(), []int{1}) {
+		t.Error("'<!-- a -->x': unexpected matches", m.Partial(), m.Full())
+	}
+	if m.MatchNext('-') != NoMatch {
+		t.Error("'<!--.*?-->' matched past its earliest end")
+	}
+}
+
+func TestSetPatternMatcher(t *testing.T) {
+	m := MustCompileSet("ab+c", "a[0-9]").Matcher()
+	m.MatchNext('a')
+	m.MatchNext('b')
+	pm := m.PatternMatcher(0)
+	if pm.LastMatch != PartialMatch || pm.Matched != "ab" || pm.MatchNext('c') != FullMatch {
+		t.Error("pattern matcher not in the state of the set matcher", pm.Matched, pm.LastMatch)
+	}
+}
+
+func TestSetErrors(t *testing.T) {
+	_, err := CompileSet("a+", "(b")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Pattern != "(b" {
+		t.Error("expected a syntax error in '(b', got", err)
+	}
+
+	_, err = Config{MaxStates: 100}.CompileSet("a{40}", "b{40}", "c{40}")
+	var sizeErr *SizeError
+	if !errors.As(err, &sizeErr) {
+		t.Error("expected a size error, got", err)
+	}
+
+	s := NewSet()
+	if s.Match("") != nil || s.Matcher().MatchNext('a') != NoMatch {
+		t.Error("the empty set matched")
+	}
+}
+
+func TestSetMinimise(t *testing.T) {
+	// states reached by the same text are not merged when different patterns match
+	for _, c := range []Config{{Minimise: true}, {Minimise: false}} {
+		s, err := c.CompileSet("ab", "cb", "[ac]b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := s.Match("ab"); !slices.Equal(m, []int{0, 2}) {
+			t.Error("'ab': expected [0 2], got", m, c)
+		}
+		if m := s.Match("cb"); !slices.Equal(m, []int{1, 2}) {
+			t.Error("'cb': expected [1 2], got", m, c)
+		}
+	}
+}
+
+func TestSetAnalyze(t *testing.T) {
+	s := MustCompileSet("[a-z]+", "let", "[0-9]+", "0|[1-9][0-9]*", "x*", "if\\b", "\\bif", "a&b")
+	a := s.Analyze()
+	shadowed := map[int][]int{1: {0}, 3: {2}, 5: {0}, 6: {0}}
+	if len(a.Shadowed) != len(shadowed) {
+		t.Errorf("expected shadowed %v, got %v", shadowed, a.Shadowed)
+	}
+	for i, by := range shadowed {
+		if got, ok := a.Shadowed[i]; !ok || !slices.Equal(got, by) {
+			t.Errorf("expected %d to be shadowed by %v, got %v, %v", i, by, got, ok)
+		}
+	}
+	overlaps := []SetOverlap{
+		{0, 1, "let"}, {0, 4, "x"}, {0, 5, "if"}, {0, 6, "if"}, {2, 3, "0"}, {5, 6, "if"},
+	}
+	if !slices.Equal(a.Overlaps, overlaps) {
+		t.Errorf("expected overlaps %v, got %v", overlaps, a.Overlaps)
+	}
+	if !slices.Equal(a.Empty, []int{4}) {
+		t.Errorf("expected x* to match the empty string, got %v", a.Empty)
+	}
+	if !slices.Equal(a.Unmatched, []int{7}) {
+		t.Errorf("expected a&b to match nothing, got %v", a.Unmatched)
+	}
+}
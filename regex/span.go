@@ -3,6 +3,9 @@ package regex
 import (
 	"math/rand"
 	"slices"
+	"strconv"
+	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -72,39 +75,42 @@ func (r spanSet) minus(other spanSet) spanSet {
 		for j < len(r2) && left.from > r2[j].to {
 			j++
 		}
-		if j == len(r2) || left.to < r2[j].from {
-			result = append(result, left)
-		} else {
-			reachedEnd := false
-			for j < len(r2) && left.to >= r2[j].from {
-				if left.from < r2[j].from {
-					result = append(result, span{left.from, r2[j].from - 1})
-				}
-				if left.to <= r2[j].to {
-					reachedEnd = true
-				} else {
-					left.from = r2[j].to + 1
-				}
-				j++
+		// a span of other can overlap several spans of r, so it is only skipped above
+		// once it is entirely before the current span
+		for k := j; k < len(r2) && left.to >= r2[k].from; k++ {
+			if left.from < r2[k].from {
+				result = append(result, span{left.from, r2[k].from - 1})
 			}
-			if !reachedEnd {
-				result = append(result, left)
+			left.from = r2[k].to + 1
+			if left.from > left.to {
+				break
 			}
 		}
+		if left.from <= left.to {
+			result = append(result, left)
+		}
 	}
 
 	return result
 }
 
+// intersect returns the characters that are in both span sets.
+func (r spanSet) intersect(other spanSet) spanSet {
+	return r.minus(r.minus(other))
+}
+
+// compact returns an equivalent sorted span set where overlapping and adjacent spans
+// are merged. The span set itself is not modified.
 func (r spanSet) compact() spanSet {
 	if len(r) <= 1 {
 		return r[:]
 	}
+	r = slices.Clone(r)
 	r.sort()
 	result := spanSet{r[0]}
 	for i := 1; i < len(r); i++ {
 		last := &result[len(result)-1]
-		if last.intersect(r[i]) {
+		if last.intersect(r[i]) || last.to+1 == r[i].from {
 			if last.to < r[i].to {
 				last.to = r[i].to
 			}
@@ -121,6 +127,65 @@ func (r spanSet) sort() {
 	})
 }
 
+// pattern returns a character class expression matching the characters of this
+// span set, which must be compact.
+func (r spanSet) pattern() string {
+	if len(r) == 1 && r[0].from == r[0].to {
+		if !unicode.IsPrint(r[0].from) {
+			return hexEscape(r[0].from)
+		}
+		return Escape(string(r[0].from))
+	}
+	if slices.Equal(r, allUnicode) {
+		return "."
+	}
+	var s strings.Builder
+	s.WriteRune('[')
+	for _, sp := range r {
+		s.WriteString(escapeInClass(sp.from))
+		if sp.to > sp.from {
+			if sp.to > sp.from+1 {
+				s.WriteRune('-')
+			}
+			s.WriteString(escapeInClass(sp.to))
+		}
+	}
+	s.WriteRune(']')
+	return s.String()
+}
+
+// escapeInClass returns the character as written in a character set, escaping the
+// characters special in sets and writing those which are not printable as \x{h},
+// which the parser reads back.
+func escapeInClass(c rune) string {
+	switch {
+	case c == '\\' || c == ']' || c == '[' || c == '-' || c == '^':
+		return "\\" + string(c)
+	case unicode.IsPrint(c):
+		return string(c)
+	default:
+		return hexEscape(c)
+	}
+}
+
+// hexEscape returns the \x{h} escape of the character.
+func hexEscape(c rune) string {
+	return "\\x{" + strconv.FormatInt(int64(c), 16) + "}"
+}
+
+// search returns true if c is in this span set, which must be compact.
+func (r spanSet) search(c rune) bool {
+	_, found := slices.BinarySearchFunc(r, c, func(s span, c rune) int {
+		if s.to < c {
+			return -1
+		} else if s.from > c {
+			return 1
+		}
+		return 0
+	})
+	return found
+}
+
 func (r spanSet) match(c rune) bool {
 	for _, s := range r {
 		if s.match(c) {
@@ -117,3 +117,35 @@ func TestMinus2(t *testing.T) {
 		t.Error("expected", s4, "actual", s3)
 	}
 }
+
+func TestMinusSpanningSeveral(t *testing.T) {
+	s1 := spanSet{{0, 10}, {12, 20}, {25, 30}}
+	s2 := spanSet{{5, 15}, {18, 26}}
+	expected := spanSet{{0, 4}, {16, 17}, {27, 30}}
+	actual := s1.minus(s2)
+	if !slices.Equal(actual, expected) {
+		t.Error("expected", expected, "actual", actual)
+	}
+}
+
+func TestCompactAdjacent(t *testing.T) {
+	s := spanSet{{5, 9}, {0, 4}, {11, 12}}
+	expected := spanSet{{0, 9}, {11, 12}}
+	actual := s.compact()
+	if !slices.Equal(actual, expected) {
+		t.Error("expected", expected, "actual", actual)
+	}
+	if s[0].from != 5 {
+		t.Error("compact modified its receiver", s)
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	s1 := spanSet{{0, 10}, {20, 30}}
+	s2 := spanSet{{5, 25}}
+	expected := spanSet{{5, 10}, {20, 25}}
+	actual := s1.intersect(s2)
+	if !slices.Equal(actual, expected) {
+		t.Error("expected", expected, "actual", actual)
+	}
+}
@@ -0,0 +1,220 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"slices"
+)
+
+type (
+	// table is a table-driven representation of a DFA used for matching. States are
+	// numbered from 0, the start state, and characters are mapped to the classes of
+	// characters taking the same transitions from every state: through a lookup array
+	// for ASCII characters and a binary search over sorted ranges for the rest. The
+	// transition on a character is then a single index in the next array.
+	table struct {
+		// states are the DFA states by state number.
+		states []state
+
+		// classes is the number of character classes.
+		classes int
+
+		// ascii is the class of each ASCII character, -1 if it has no transition.
+		ascii [128]int32
+
+		// ranges are the sorted, disjoint ranges of non-ASCII characters with a class.
+		ranges []classRange
+
+		// next[s*classes+c] is the state reached from state s on class c, -1 if none.
+		next []int32
+
+		// groups[s*classes+c] are the capture groups labelling the transition from
+		// state s on class c in the DFA.
+		groups [][]int
+
+		// final is true for each state number which is final at the end of the text.
+		final []bool
+
+		// accepts are the bit sets of the lookaheads before which each state is final,
+		// nil if finality does not depend on the next character.
+		accepts []uint8
+
+		// matches are the regular expressions partially and fully matched in each
+		// state of the DFA of a set of regular expressions, nil for other DFAs.
+		matches []*setMatch
+
+		// starts are the start state numbers by the context of the position where
+		// matching starts.
+		starts [contexts]int32
+	}
+
+	classRange struct {
+		from, to rune
+		class    int32
+	}
+)
+
+// table builds the table-driven representation of the DFA.
+func (auto *automata) table() *table {
+	states := auto.states()
+	index := make(map[state]int, len(states))
+	for i, s := range states {
+		index[s] = i
+	}
+
+	t := &table{states: states, final: make([]bool, len(states))}
+	final := set[state]{}
+	for _, s := range auto.final {
+		final[s] = true
+	}
+	for i, s := range states {
+		t.final[i] = final[s]
+	}
+	if auto.accepts != nil {
+		t.accepts = make([]uint8, len(states))
+		for i, s := range states {
+			t.accepts[i] = auto.accepts[s]
+		}
+	}
+	if auto.matches != nil {
+		t.matches = make([]*setMatch, len(states))
+		for i, s := range states {
+			t.matches[i] = auto.matches[s]
+		}
+	}
+	for ctx, s := range auto.startStates() {
+		t.starts[ctx] = int32(index[s])
+	}
+
+	var alphabet []*symbol
+	var classes []spanSet
+	for _, a := range auto.alphabet(states, index) {
+		// characters used only for generation never match
+		if a.generator == nil {
+			alphabet = append(alphabet, a)
+			classes = append(classes, a.spans)
+		}
+	}
+	t.setClasses(classes)
+
+	t.next = make([]int32, len(states)*t.classes)
+	t.groups = make([][]int, len(states)*t.classes)
+	groups := map[char][]int{}
+	for i, s := range states {
+		for class, a := range alphabet {
+			t.next[i*t.classes+class] = -1
+			if c := a.transition(auto.Trans[s]); c != nil {
+				t.next[i*t.classes+class] = int32(index[auto.Trans[s][c]])
+				g, ok := groups[c]
+				if !ok {
+					for e := c.groups().Front(); e != nil; e = e.Next() {
+						g = append(g, e.Value.(int))
+					}
+					groups[c] = g
+				}
+				t.groups[i*t.classes+class] = g
+			}
+		}
+	}
+	return t
+}
+
+// setClasses maps the characters of each class to its index.
+func (t *table) setClasses(classes []spanSet) {
+	t.classes = len(classes)
+	for c := range t.ascii {
+		t.ascii[c] = -1
+	}
+	t.ranges = nil
+	for class, spans := range classes {
+		for _, s := range spans {
+			for c := s.from; c <= s.to && c < 128; c++ {
+				t.ascii[c] = int32(class)
+			}
+			if s.to >= 128 {
+				t.ranges = append(t.ranges, classRange{max(s.from, 128), s.to, int32(class)})
+			}
+		}
+	}
+	slices.SortFunc(t.ranges, func(a, b classRange) int {
+		return int(a.from) - int(b.from)
+	})
+}
+
+// classSpans returns the characters of each class by class index.
+func (t *table) classSpans() []spanSet {
+	classes := make([]spanSet, t.classes)
+	for c, class := range t.ascii {
+		if class < 0 {
+			continue
+		}
+		if spans := classes[class]; len(spans) > 0 && spans[len(spans)-1].to == rune(c)-1 {
+			spans[len(spans)-1].to = rune(c)
+		} else {
+			classes[class] = append(spans, span{rune(c), rune(c)})
+		}
+	}
+	for _, r := range t.ranges {
+		classes[r.class] = append(classes[r.class], span{r.from, r.to})
+	}
+	for i := range classes {
+		classes[i] = classes[i].compact()
+	}
+	return classes
+}
+
+// class returns the class of the character, or -1 if the character has no
+// transition from any state.
+func (t *table) class(c rune) int32 {
+	if c >= 0 && c < 128 {
+		return t.ascii[c]
+	}
+	i, found := slices.BinarySearchFunc(t.ranges, c, func(r classRange, c rune) int {
+		if r.to < c {
+			return -1
+		} else if r.from > c {
+			return 1
+		}
+		return 0
+	})
+	if !found {
+		return -1
+	}
+	return t.ranges[i].class
+}
+
+// step returns the state reached from state s on the character, or -1 if there is
+// no transition.
+func (t *table) step(s int32, c rune) int32 {
+	class := t.class(c)
+	if class < 0 {
+		return -1
+	}
+	return t.next[int(s)*t.classes+int(class)]
+}
+
+// stepGroups returns the state reached from state s on the character, or -1 if there
+// is no transition, together with the capture groups labelling the transition.
+func (t *table) stepGroups(s int32, c rune) (int32, []int) {
+	class := t.class(c)
+	if class < 0 {
+		return -1, nil
+	}
+	i := int(s)*t.classes + int(class)
+	return t.next[i], t.groups[i]
+}
+
+// acceptsBefore returns true if state s is final when followed by the character, or
+// by the end of the text if the character is negative.
+func (t *table) acceptsBefore(s int32, c rune) bool {
+	if t.accepts == nil || c < 0 {
+		return t.final[s]
+	}
+	return t.accepts[s]&(1<<lookaheadBefore(c)) != 0
+}
+
+// startAfter returns the start state for matching after the character, or at the
+// start of the text if the character is negative.
+func (t *table) startAfter(c rune) int32 {
+	return t.starts[contextAfter(c)]
+}
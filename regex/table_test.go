@@ -0,0 +1,60 @@
+// author: Vikash Madhow (vikash.madhow@gmail.com)
+
+package regex
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestTableClasses(t *testing.T) {
+	r := NewRegex("[a-z]+|x1|日本")
+	if r.table.class('a') != r.table.class('q') {
+		t.Error("'a' and 'q' should be in the same class")
+	}
+	if r.table.class('a') == r.table.class('x') {
+		t.Error("'a' and 'x' should be in different classes")
+	}
+	if r.table.class('?') != -1 {
+		t.Error("'?' should have no class")
+	}
+	if r.table.class('日') == -1 || r.table.class('本') == -1 || r.table.class('語') != -1 {
+		t.Error("non-ASCII classes are wrong")
+	}
+}
+
+func TestTableMatchesDfa(t *testing.T) {
+	alphabet := []rune("abcxyz019_-.@日本語 ")
+	for _, pattern := range []string{
+		"[a-z]+|x1",
+		"\\w(\\w|[日本語])*",
+		"[a-z0-9._]+@[a-z0-9]+(\\.[a-z]+)+",
+		"(?i)[A-C]{2,3}x?",
+		"[^a-c]*\\d",
+	} {
+		r := NewRegex(pattern)
+		for i := 0; i < 500; i++ {
+			s := make([]rune, rand.Intn(8))
+			for j := range s {
+				s[j] = alphabet[rand.Intn(len(alphabet))]
+			}
+			if r.Match(string(s)) != mapMatch(r, string(s)) {
+				t.Errorf("%q: table and DFA disagree on %q", pattern, string(s))
+			}
+		}
+	}
+}
+
+func TestMatcherStopsAfterNoMatch(t *testing.T) {
+	m := NewRegex("ab").Matcher()
+	if m.MatchNext('b') != NoMatch {
+		t.Error("'ab' matched 'b'")
+	}
+	if m.MatchNext('a') != NoMatch {
+		t.Error("matcher continued matching after a failed match")
+	}
+	m.Reset()
+	if m.MatchNext('a') != PartialMatch || m.MatchNext('b') != FullMatch || m.Matched != "ab" {
+		t.Error("'ab' did not match 'ab' after reset")
+	}
+}